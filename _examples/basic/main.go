@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/nesbyte/loadr"
 )
@@ -13,9 +12,11 @@ import (
 //go:embed "*"
 var baseFS embed.FS
 
-// By default (production), use the embedded file system
+// In production the embedded file system is used. While live reloading,
+// the files are read from the source directory (".") instead, so edits
+// show up without recompiling
 var config = loadr.BaseConfig{
-	FS: baseFS,
+	FS: loadr.SourceFS(baseFS, "."),
 }
 
 type baseData struct {
@@ -74,15 +75,9 @@ func main() {
 	liveReload := true
 	if liveReload {
 
-		// In dev, use the os filesystem instead of the embedded one
-		// to allow for live reloads to take place
-		var config = loadr.BaseConfig{
-			FS: os.DirFS("."),
-		}
-		base.SetConfig(config) // resetting the config is ok!
-
 		// Live reload takes in the pattern of which the HTTP server will listen on (/live-reload)
 		// and allows some insertion of custom logic of what to do if a file has changed.
+		// The source directory of the config is validated and watched as well.
 		r.Handle("/live-reload", loadr.MustRunLiveReload("/live-reload", nil, "."))
 
 	}
@@ -2,9 +2,14 @@ package loadr
 
 import (
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
@@ -78,6 +83,162 @@ func BenchmarkLoadrInProductionMode(b *testing.B) {
 	}
 }
 
+// Using loadr with templates loaded and buffered rendering enabled
+func BenchmarkLoadrBufferedInProductionMode(b *testing.B) {
+
+	buffered := loadr.NewTemplateContext(config, loadr.NoData, "index.html", "components.html").SetBuffered(true)
+	t := loadr.NewTemplate(buffered, testData{})
+	err := loadr.LoadTemplates()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range sampleSizes {
+		data := testData{}
+		data.Test = strings.Repeat(sample.Test, size)
+
+		b.Run(fmt.Sprintf(
+			"Size_%d", size),
+			func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					var bs bytes.Buffer
+					bs.Reset()
+					t.Render(&bs, data)
+				}
+			})
+	}
+}
+
+// Using loadr with templates loaded and served as HTTP responses, which are buffered
+func BenchmarkLoadrServeInProductionMode(b *testing.B) {
+	benchmarkServe(b, base)
+}
+
+// Using loadr with templates loaded and served as gzip compressed HTTP responses
+func BenchmarkLoadrServeGzipInProductionMode(b *testing.B) {
+	compressed := loadr.NewTemplateContext(config, loadr.NoData, "index.html", "components.html").
+		SetCompression(loadr.DefaultMinCompressSize, loadr.GzipEncoder(gzip.DefaultCompression))
+	benchmarkServe(b, compressed)
+}
+
+func benchmarkServe(b *testing.B, tc *loadr.TemplateContext[int]) {
+	t := loadr.NewTemplate(tc, testData{})
+	err := loadr.LoadTemplates()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	for _, size := range sampleSizes {
+		data := testData{}
+		data.Test = strings.Repeat(sample.Test, size)
+
+		b.Run(fmt.Sprintf(
+			"Size_%d", size),
+			func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					var w discardResponseWriter
+					err := t.Serve(&w, r, http.StatusOK, data)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+	}
+}
+
+// Discards the response to only measure the rendering
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+var templateCounts = []int{1, 10, 100}
+
+// Using html/templates parsing and executing (to validate) every template
+// separately on startup
+func BenchmarkStdLoadTemplates(b *testing.B) {
+	for _, count := range templateCounts {
+		b.Run(fmt.Sprintf(
+			"Templates_%d", count),
+			func(b *testing.B) {
+				var templates []*template.Template
+				heapBefore := heapAlloc()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					templates = templates[:0]
+					for j := 0; j < count; j++ {
+						t, err := template.ParseFS(htmlDir, "index.html", "components.html")
+						if err != nil {
+							b.Fatal(err)
+						}
+						err = t.ExecuteTemplate(io.Discard, "index.html", struct{ D testData }{})
+						if err != nil {
+							b.Fatal(err)
+						}
+						templates = append(templates, t)
+					}
+				}
+				b.StopTimer()
+				reportHeapPerTemplate(b, heapBefore, count)
+				runtime.KeepAlive(templates)
+			})
+	}
+}
+
+// Using loadr with many templates derived from the same base context,
+// the base templates are parsed once and cloned for every template
+func BenchmarkLoadrLoadTemplates(b *testing.B) {
+	for _, count := range templateCounts {
+		b.Run(fmt.Sprintf(
+			"Templates_%d", count),
+			func(b *testing.B) {
+				heapBefore := heapAlloc()
+				reg := loadr.NewRegistry()
+				base := loadr.NewTemplateContext(config, loadr.NoData, "index.html").SetRegistry(reg)
+				for j := 0; j < count; j++ {
+					loadr.NewTemplate(base.WT("components.html"), testData{})
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := reg.LoadTemplates()
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				reportHeapPerTemplate(b, heapBefore, count)
+				runtime.KeepAlive(reg)
+			})
+	}
+}
+
+// Reports the heap retained since heapBefore divided by the number of templates
+func reportHeapPerTemplate(b *testing.B, heapBefore uint64, count int) {
+	b.ReportMetric(float64(heapAlloc()-heapBefore)/float64(count), "heap-B/template")
+}
+
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
 // Using html/templates with the templates re-parsed on every iteration
 func BenchmarkStdTemplatesWithLiveReload(b *testing.B) {
 
@@ -0,0 +1,31 @@
+package loadr
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Pool of buffers used for buffered rendering to avoid
+// allocating a new buffer on every Render call
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// Buffers which grew larger than this are not pooled, so that a single
+// large render does not keep its memory alive for every later render
+const maxPooledBufferSize = 64 << 10
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
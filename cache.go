@@ -0,0 +1,144 @@
+package loadr
+
+import (
+	"html/template"
+	"io/fs"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nesbyte/loadr/registry"
+)
+
+// parseCache caches the parsed templates of all the TemplateContexts bound to a registry
+// so that identical pattern sets over the same FS are only parsed once per LoadTemplates call.
+// It is only kept while LoadTemplates is running, see (*registry.Registry).Value.
+// The base templates are parsed once and the with templates are parsed
+// incrementally on top of a clone of them.
+//
+// The cached templates are never executed, every caller gets its own Clone()
+// as html/template does not allow cloning a template after it has executed.
+type parseCache struct {
+	mu         sync.Mutex
+	generation uint64 // The registry generation the entries belong to
+	entries    map[parseKey]*parseEntry
+}
+
+// parseKey identifies a parsed pattern set. Parsing only depends on the names of
+// the functions, the functions themselves are bound to every clone with Funcs.
+type parseKey struct {
+	fsys  any    // The identity of the FS, see fsIdentity
+	funcs string // The sorted function names
+	base  string
+	with  string
+}
+
+type parseEntry struct {
+	once sync.Once
+	t    *template.Template
+	err  error
+}
+
+type parseCacheKey struct{}
+
+// Returns the parse cache shared by the templates of the registry
+func parseCacheOf(r *registry.Registry) *parseCache {
+	return r.Value(parseCacheKey{}, func() any { return &parseCache{} }).(*parseCache)
+}
+
+// Returns a value identifying fsys which can be used as a map key. File systems which
+// are not comparable are identified by their pointer, ok is false if that is not possible.
+func fsIdentity(fsys fs.FS) (id any, ok bool) {
+	v := reflect.ValueOf(fsys)
+	if !v.IsValid() {
+		return nil, false
+	}
+	if v.Comparable() {
+		return fsys, true
+	}
+
+	switch v.Kind() {
+	case reflect.Map, reflect.Func:
+		return struct {
+			typ reflect.Type
+			ptr uintptr
+		}{v.Type(), v.Pointer()}, true
+	}
+
+	return nil, false
+}
+
+// Returns a clone of the template parsed from the base and with patterns with the functions
+// of funcMap, parsing the patterns only if they have not been parsed during the generation.
+func (c *parseCache) parse(generation uint64, fsys fs.FS, funcMap template.FuncMap, baseTemplates, withTemplates []string) (*template.Template, error) {
+	parseBase := func() (*template.Template, error) {
+		t := template.New("").Funcs(funcMap)
+		if len(baseTemplates) == 0 {
+			return t, nil
+		}
+		return t.ParseFS(fsys, baseTemplates...)
+	}
+	parseWith := func(base *template.Template) (*template.Template, error) {
+		return base.ParseFS(fsys, withTemplates...)
+	}
+
+	id, ok := fsIdentity(fsys)
+	if !ok {
+		t, err := parseBase()
+		if err != nil || len(withTemplates) == 0 {
+			return t, err
+		}
+		return parseWith(t)
+	}
+
+	names := make([]string, 0, len(funcMap))
+	for name := range funcMap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	baseKey := parseKey{fsys: id, funcs: strings.Join(names, "\x00"), base: strings.Join(baseTemplates, "\x00")}
+	if len(withTemplates) == 0 {
+		return c.get(generation, baseKey, funcMap, parseBase)
+	}
+
+	withKey := baseKey
+	withKey.with = strings.Join(withTemplates, "\x00")
+	return c.get(generation, withKey, funcMap, func() (*template.Template, error) {
+		t, err := c.get(generation, baseKey, funcMap, parseBase)
+		if err != nil {
+			return nil, err
+		}
+		return parseWith(t)
+	})
+}
+
+// Returns a clone of the cached template for the key with the functions of funcMap,
+// calling parse only if the key has not been parsed yet during the generation.
+func (c *parseCache) get(generation uint64, key parseKey, funcMap template.FuncMap, parse func() (*template.Template, error)) (*template.Template, error) {
+	c.mu.Lock()
+	if c.entries == nil || c.generation != generation {
+		c.entries = make(map[parseKey]*parseEntry)
+		c.generation = generation
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &parseEntry{}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		e.t, e.err = parse()
+	})
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	t, err := e.t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.Funcs(funcMap), nil
+}
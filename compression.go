@@ -0,0 +1,109 @@
+package loadr
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder compresses the responses written by Serve and Handler.
+// Encoders must be safe for concurrent use.
+type Encoder interface {
+	Encoding() string                      // The Content-Encoding token, such as gzip or br
+	Encode(w io.Writer, body []byte) error // Writes the compressed body to w
+}
+
+// Outputs smaller than this are not compressed by default,
+// as the overhead outweighs the savings
+const DefaultMinCompressSize = 1024
+
+// Returns an Encoder compressing with gzip at the level, which reuses its writers.
+// Invalid levels are treated as gzip.DefaultCompression.
+func GzipEncoder(level int) Encoder {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+
+	e := &gzipEncoder{}
+	e.pool.New = func() any {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	return e
+}
+
+type gzipEncoder struct {
+	pool sync.Pool
+}
+
+func (e *gzipEncoder) Encoding() string {
+	return "gzip"
+}
+
+func (e *gzipEncoder) Encode(w io.Writer, body []byte) error {
+	gw := e.pool.Get().(*gzip.Writer)
+	defer e.pool.Put(gw)
+
+	gw.Reset(w)
+	_, err := gw.Write(body)
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// compression is the compression configuration of a TemplateContext
+type compression struct {
+	minSize  int
+	encoders []Encoder // In order of preference
+}
+
+// Returns the most preferred encoder accepted by the Accept-Encoding header,
+// or nil if the response should not be compressed
+func (c *compression) negotiate(r *http.Request, size int) Encoder {
+	if c == nil || size < c.minSize {
+		return nil
+	}
+
+	accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+	for _, e := range c.encoders {
+		q, ok := accepted[strings.ToLower(e.Encoding())]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Returns the quality value of every coding in an Accept-Encoding header
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				parsed, err := strconv.ParseFloat(value, 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+
+	return accepted
+}
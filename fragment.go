@@ -0,0 +1,154 @@
+package loadr
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nesbyte/loadr/registry"
+)
+
+// The number of recent renders remembered per SubTemplate for re-rendering
+const maxLiveRenders = 32
+
+// Used to give every live render a unique fragment target
+var liveRenderID atomic.Uint64
+
+// liveState is the state of a template while live reloading
+type liveState struct {
+	mu       sync.Mutex   // Guards the reloading and rendering of the template
+	fragment bool         // If true, the renders are marked and can be re-rendered in place
+	renders  []liveRender // The most recent renders, oldest first
+
+	sources    map[string]sourceStamp // The sources of the last successful load, nil if it must be reloaded
+	generation uint64                 // The registry generation of the last successful load
+}
+
+// The modification time and size of a source file, used to detect changes
+type sourceStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+type liveRender struct {
+	target string
+	data   any
+}
+
+// Checks if the live output of the template can be marked to be re-rendered in place.
+// Marking must be enabled for the live reloader and only whole elements served as HTML
+// are marked, as the comments would break other content types, text such as the
+// content of a <title> and attribute values.
+func (t *SubTemplate[U]) markable(html string) bool {
+	if !t.live.fragment || !t.ctx.registry.MarkFragments() {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(t.ContentType())
+	if err != nil || mediaType != "text/html" {
+		return false
+	}
+
+	html = strings.TrimSpace(html)
+	return len(html) > 2 && html[0] == '<' && isASCIILetter(html[1]) && html[len(html)-1] == '>'
+}
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// Wraps the html in comments marking the fragment target and remembers
+// the data so that it can be re-rendered in place when its sources change.
+// Must be called with mu held.
+func (l *liveState) mark(html string, data any) string {
+	target := strconv.FormatUint(liveRenderID.Add(1), 10)
+
+	l.renders = append(l.renders, liveRender{target, data})
+	if len(l.renders) > maxLiveRenders {
+		l.renders = l.renders[len(l.renders)-maxLiveRenders:]
+	}
+
+	return "<!--loadr-fragment:" + target + "-->" + html + "<!--/loadr-fragment:" + target + "-->"
+}
+
+// Returns the slash separated paths of the files in BaseConfig.FS
+// the template is parsed from
+func (t *SubTemplate[U]) Sources() []string {
+	if t.ctx.config == nil || t.ctx.config.FS == nil {
+		return nil
+	}
+
+	var sources []string
+	for _, patterns := range [][]string{t.ctx.baseTemplates, t.ctx.withTemplates} {
+		for _, pattern := range patterns {
+			matches, err := fs.Glob(t.ctx.config.FS, pattern)
+			if err != nil {
+				continue
+			}
+			sources = append(sources, matches...)
+		}
+	}
+
+	return sources
+}
+
+// Returns the stamps of the sources of the template by their path. Files which
+// start or stop matching the patterns change the returned sources as well.
+func (t *SubTemplate[U]) statSources() (map[string]sourceStamp, error) {
+	sources := t.Sources()
+	stamps := make(map[string]sourceStamp, len(sources))
+	for _, source := range sources {
+		fi, err := fs.Stat(t.ctx.config.FS, source)
+		if err != nil {
+			return nil, err
+		}
+		stamps[source] = sourceStamp{fi.ModTime(), fi.Size()}
+	}
+
+	return stamps, nil
+}
+
+// Re-renders the recent live renders of the template with their data so they can
+// be swapped in place on the page. Templates created with NewTemplate are pages and
+// can not be re-rendered in place, nor can any template unless LiveReloadConfig.Fragments is set.
+//
+// This should rarely be called directly, it is used by the live reloader when
+// the sources of the template change.
+func (t *SubTemplate[U]) Fragments() ([]registry.Fragment, bool) {
+	if !t.live.fragment || !t.ctx.registry.MarkFragments() {
+		return nil, false
+	}
+
+	t.live.mu.Lock()
+	defer t.live.mu.Unlock()
+
+	if len(t.live.renders) == 0 {
+		return nil, true
+	}
+
+	// On failure the error overlay is shown, as the page itself is not affected
+	err := t.reload(t.live.renders[len(t.live.renders)-1].data)
+	if err != nil {
+		t.ctx.registry.Notify(err)
+		return nil, true
+	}
+
+	fragments := make([]registry.Fragment, 0, len(t.live.renders))
+	for _, r := range t.live.renders {
+		var buf bytes.Buffer
+		err := t.t.ExecuteTemplate(&buf, t.usePattern, r.data)
+		if err != nil {
+			t.ctx.registry.Notify(newTemplateError(t.ctx, t.usePattern, fmt.Errorf("%w: %w", ErrTemplateExecute, err)))
+			return nil, true
+		}
+		fragments = append(fragments, registry.Fragment{Target: r.target, HTML: buf.String()})
+	}
+
+	return fragments, true
+}
@@ -0,0 +1,80 @@
+package loadr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+var ErrUnknownFragment = errors.New("fragment has not been added to the template")
+
+// Adds named fragment entry points to the template, which are templates defined in
+// its base or with templates. Fragments share the parse tree and data type of the template,
+// they are executed with the same .B and .D data and validated when LoadTemplates is called.
+//
+// Serve and Handler respond with a fragment instead of the whole page for htmx requests,
+// which have the HX-Request header set, targeting an element with the id of the fragment
+// in the HX-Target header:
+//
+//	{{define "todo-list"}}<ul id="todo-list">...</ul>{{end}}
+//	<button hx-get="/todos" hx-target="#todo-list">Refresh</button>
+//
+// AddFragments must be called before LoadTemplates.
+func (t *Template[T, U]) AddFragments(names ...string) *Template[T, U] {
+	for _, name := range names {
+		if !slices.Contains(t.fragments, name) {
+			t.fragments = append(t.fragments, name)
+		}
+	}
+	return t
+}
+
+// Renders the fragment added with AddFragments with the base data and data, see RenderE
+func (t *Template[T, U]) RenderFragment(w io.Writer, name string, data U) error {
+	if !slices.Contains(t.fragments, name) {
+		return newTemplateError(t.ctx, name, ErrUnknownFragment)
+	}
+
+	d := BaseData[T, U]{B: *t.baseData, D: data}
+	return t.renderE(w, name, d)
+}
+
+// Returns the name of the fragment requested by an htmx request,
+// or the usePattern if the whole page should be rendered
+func (t *Template[T, U]) selectFragment(h http.Header, r *http.Request) string {
+	if len(t.fragments) == 0 {
+		return t.usePattern
+	}
+
+	// The response differs for htmx requests
+	addVary(h, "HX-Request")
+	addVary(h, "HX-Target")
+
+	if r.Header.Get("HX-Request") != "true" {
+		return t.usePattern
+	}
+
+	target := r.Header.Get("HX-Target")
+	if slices.Contains(t.fragments, target) {
+		return target
+	}
+	return t.usePattern
+}
+
+// Validates that every fragment is defined and executes with data
+func (t *SubTemplate[U]) validateFragments(data any) error {
+	for _, name := range t.fragments {
+		if t.t.Lookup(name) == nil {
+			return newTemplateError(t.ctx, name, fmt.Errorf("%w: no template %q is defined", ErrTemplateExecute, name))
+		}
+
+		err := t.t.ExecuteTemplate(io.Discard, name, data)
+		if err != nil {
+			return newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package loadr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The Content-Type set by Serve and Handler unless SetContentType is used
+const DefaultContentType = "text/html; charset=utf-8"
+
+// StatusError can be returned by a Handler data function to
+// respond with the Status instead of http.StatusInternalServerError
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Err)
+}
+
+func (e StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Sets the Content-Type of the responses written by Serve and Handler
+func (t *SubTemplate[U]) SetContentType(contentType string) {
+	t.contentType = contentType
+}
+
+// Returns the Content-Type of the responses written by Serve and Handler
+func (t *SubTemplate[U]) ContentType() string {
+	if t.contentType == "" {
+		return DefaultContentType
+	}
+	return t.contentType
+}
+
+// Enables or disables ETags for the responses written by Serve and Handler.
+//
+// When enabled, successful responses with http.StatusOK get a strong ETag hashed from
+// the rendered output, and GET and HEAD requests with a matching If-None-Match header
+// are answered with http.StatusNotModified without a body. The template is still
+// rendered for every request, but the output is not sent if the client has it already.
+func (t *SubTemplate[U]) SetETag(enabled bool) {
+	t.etag = enabled
+}
+
+// Renders the template like RenderE and writes it as the response with the status,
+// the Content-Type and the Content-Length. As the template is rendered into a buffer
+// first, the response is never partially written.
+//
+// If rendering fails, the error template set with SetErrorTemplate is written with
+// http.StatusInternalServerError instead, or a plain text error if it is not set, and the
+// error is returned. When live reloading, the error overlay is written instead.
+//
+// For htmx requests targeting a fragment added with AddFragments, only the fragment is written.
+func (t *Template[T, U]) Serve(w http.ResponseWriter, r *http.Request, status int, data U) error {
+	d := BaseData[T, U]{B: *t.baseData, D: data}
+	return t.serve(w, r, t.selectFragment(w.Header(), r), status, d)
+}
+
+// Returns an http.Handler which serves the template with the data returned
+// by the data function, see Serve.
+//
+// If the data function returns an error, the error template is written with the status of
+// a StatusError or http.StatusInternalServerError. Errors which occur while serving can not
+// be returned, use Serve in a custom handler to handle them.
+func (t *Template[T, U]) Handler(data func(*http.Request) (U, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := data(r)
+		if err != nil {
+			t.serveError(w, r, err)
+			return
+		}
+		t.Serve(w, r, http.StatusOK, d)
+	})
+}
+
+// Renders the template like RenderE and writes it as the response, see (*Template).Serve
+func (t *SubTemplate[U]) Serve(w http.ResponseWriter, r *http.Request, status int, data U) error {
+	return t.serve(w, r, t.usePattern, status, data)
+}
+
+// Returns an http.Handler which serves the template with the data
+// returned by the data function, see (*Template).Handler
+func (t *SubTemplate[U]) Handler(data func(*http.Request) (U, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := data(r)
+		if err != nil {
+			t.serveError(w, r, err)
+			return
+		}
+		t.Serve(w, r, http.StatusOK, d)
+	})
+}
+
+func (t *SubTemplate[U]) serve(w http.ResponseWriter, r *http.Request, name string, status int, d any) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	// When live reloading the error overlay is written on failure
+	var err error
+	if t.ctx.registry.LiveReload() {
+		err = t.renderE(buf, name, d)
+	} else {
+		err = t.execute(buf, name, d)
+	}
+
+	if err != nil {
+		status = http.StatusInternalServerError
+		if buf.Len() == 0 {
+			http.Error(w, http.StatusText(status), status)
+			return err
+		}
+	}
+
+	enc := t.ctx.render.compression.negotiate(r, buf.Len())
+	if err == nil && t.etag && status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		etag := hashETag(buf.Bytes(), enc)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			if t.ctx.render.compression != nil {
+				addVary(w.Header(), "Accept-Encoding")
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	werr := t.writeResponse(w, r, status, buf, enc)
+	if werr != nil {
+		return errors.Join(err, newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, werr)))
+	}
+
+	return err
+}
+
+// Writes the error template with the error of a Handler data function
+func (t *SubTemplate[U]) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.Status
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if t.ctx.registry.LiveReload() {
+		t.live.mu.Lock()
+		t.executeErrorTemplate(buf, err, status)
+		t.live.mu.Unlock()
+	} else {
+		t.executeErrorTemplate(buf, err, status)
+	}
+
+	if buf.Len() == 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	t.writeResponse(w, r, status, buf, t.ctx.render.compression.negotiate(r, buf.Len()))
+}
+
+// Writes the headers and, if allowed for the request and status, the body
+// compressed with enc. If enc is nil or fails, the body is sent uncompressed.
+func (t *SubTemplate[U]) writeResponse(w http.ResponseWriter, r *http.Request, status int, body *bytes.Buffer, enc Encoder) error {
+	h := w.Header()
+	h.Set("Content-Type", t.ContentType())
+	if t.ctx.render.compression != nil {
+		addVary(h, "Accept-Encoding")
+	}
+
+	if !bodyAllowed(status) {
+		w.WriteHeader(status)
+		return nil
+	}
+
+	if enc != nil {
+		compressed := getBuffer()
+		defer putBuffer(compressed)
+
+		if enc.Encode(compressed, body.Bytes()) == nil {
+			h.Set("Content-Encoding", enc.Encoding())
+			body = compressed
+		}
+	}
+
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	_, err := body.WriteTo(w)
+	return err
+}
+
+// Informational, no content and not modified responses must not have a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// Adds the field to the Vary header unless it is already present
+func addVary(h http.Header, field string) {
+	for _, value := range h.Values("Vary") {
+		for _, f := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// Returns a strong ETag of the body, which differs for every
+// encoding as the compressed bodies differ
+func hashETag(body []byte, enc Encoder) string {
+	sum := sha256.Sum256(body)
+	if enc != nil {
+		return `"` + hex.EncodeToString(sum[:16]) + "-" + enc.Encoding() + `"`
+	}
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Checks if any of the entity tags of an If-None-Match header match the etag.
+// As required for If-None-Match, weak tags are compared as if they were strong.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package livereload
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"io"
+)
+
+//go:embed errorOverlay.html
+var errorOverlayHTML embed.FS
+
+var errorOverlay = template.Must(template.ParseFS(errorOverlayHTML, "errorOverlay.html"))
+
+// ErrorReport describes an error shown in the error overlay
+type ErrorReport struct {
+	Title     string
+	Message   string
+	File      string
+	Line      int
+	Column    int // -1 if it is unknown
+	CodeFrame string
+	Chain     []string // The messages of every error in the chain, outermost first
+}
+
+// Reporter is implemented by errors which can provide more details
+// for the error overlay than their message, such as loadr.TemplateError
+type Reporter interface {
+	Report() ErrorReport
+}
+
+// Creates the report for the error overlay.
+// If an error in the chain implements Reporter, it is used as the base of the report.
+func NewErrorReport(err error) ErrorReport {
+	var report ErrorReport
+
+	var reporter Reporter
+	if errors.As(err, &reporter) {
+		report = reporter.Report()
+	}
+
+	if report.Title == "" {
+		report.Title = "live reload error"
+	}
+	if report.Message == "" {
+		report.Message = err.Error()
+	}
+	report.Chain = errorChain(err, nil)
+
+	return report
+}
+
+// Flattens the error chain, including errors joined with errors.Join
+func errorChain(err error, chain []string) []string {
+	if err == nil {
+		return chain
+	}
+
+	chain = append(chain, err.Error())
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		return errorChain(u.Unwrap(), chain)
+	case interface{ Unwrap() []error }:
+		for _, e := range u.Unwrap() {
+			chain = errorChain(e, chain)
+		}
+	}
+
+	return chain
+}
+
+// Writes a complete HTML page showing the error overlay for the report,
+// the js is injected at the end of the body to keep the page connected to the live reloader.
+func WriteErrorPage(w io.Writer, report ErrorReport, js string) error {
+	return errorOverlay.ExecuteTemplate(w, "page", struct {
+		Report ErrorReport
+		JS     template.HTML
+	}{report, template.HTML(js)})
+}
+
+// Creates the event which shows the error overlay for the report on all connected clients
+func errorEvent(report ErrorReport) (event, error) {
+	var buf bytes.Buffer
+	err := errorOverlay.ExecuteTemplate(&buf, "overlay", report)
+	if err != nil {
+		return event{}, err
+	}
+
+	return event{eventTemplateError, struct {
+		HTML string `json:"html"`
+	}{buf.String()}}, nil
+}
@@ -0,0 +1,83 @@
+package livereload
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/nesbyte/loadr/registry"
+)
+
+// The event types sent to the connected clients
+const (
+	eventConnected     = "connected"      // Sent once when a client connects
+	eventReload        = "reload"         // Reloads the page
+	eventCSS           = "css"            // Swaps the changed stylesheets without reloading
+	eventFragments     = "fragments"      // Swaps re-rendered fragments without reloading
+	eventTemplateError = "template-error" // Shows the error overlay
+	eventWatchError    = "watch-error"    // The watcher failed
+)
+
+// event is a typed message sent to the connected clients
+type event struct {
+	name string
+	data any // Sent JSON encoded
+}
+
+// Formats the event as a server sent event
+func (e event) sse() []byte {
+	data, err := json.Marshal(e.data)
+	if err != nil {
+		data, _ = json.Marshal(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	}
+
+	return []byte("event: " + e.name + "\ndata: " + string(data) + "\n\n")
+}
+
+// Formats the event as a WebSocket message of the form {"event": name, "data": data}
+func (e event) json() []byte {
+	msg, err := json.Marshal(struct {
+		Event string `json:"event"`
+		Data  any    `json:"data"`
+	}{e.name, e.data})
+	if err != nil {
+		msg, _ = json.Marshal(struct {
+			Event string `json:"event"`
+			Data  any    `json:"data"`
+		}{e.name, struct {
+			Error string `json:"error"`
+		}{err.Error()}})
+	}
+
+	return msg
+}
+
+// Sent with the connected event, the ID changes when the server restarts
+type connectedData struct {
+	ID string `json:"id"`
+}
+
+type pathsData struct {
+	Paths []string `json:"paths"`
+}
+
+type fragmentsData struct {
+	Fragments []registry.Fragment `json:"fragments"`
+}
+
+// Creates the event for the changed paths, if only stylesheets
+// have changed they can be swapped without reloading the page
+func changeEvent(paths []string) event {
+	name := eventCSS
+	slashPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !strings.EqualFold(filepath.Ext(path), ".css") {
+			name = eventReload
+		}
+		slashPaths = append(slashPaths, filepath.ToSlash(path))
+	}
+
+	return event{name, pathsData{slashPaths}}
+}
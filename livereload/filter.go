@@ -0,0 +1,135 @@
+package livereload
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The filters used when Config.Filters is nil. Go files, dotfiles and
+// directories, and editor swap and backup files are not watched.
+var DefaultFilters = []string{
+	"!**/*.go",
+	"!**/.*",
+	"!**/*~",
+	"!**/*.swp",
+	"!**/*.swx",
+	"!**/#*#",
+	"!**/4913", // Written by vim to check if a directory is writable
+}
+
+// filter decides which of the paths below the watched paths are watched.
+//
+// Patterns are matched against the slash separated path relative to the watched
+// path they are in. A pattern prefixed with ! excludes the paths it matches, any
+// other pattern includes them. A file is watched if it matches an include pattern,
+// or there are none, and matches no exclude pattern. Directories matching an
+// exclude pattern are not walked.
+type filter struct {
+	roots   []string
+	include []string
+	exclude []string
+}
+
+// Creates a filter for the watched roots, returns an error
+// if any of the patterns is malformed
+func newFilter(roots []string, patterns []string) (*filter, error) {
+	f := &filter{}
+	for _, root := range roots {
+		f.roots = append(f.roots, filepath.Clean(root))
+	}
+
+	for _, p := range patterns {
+		exclude := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+
+		for _, segment := range strings.Split(p, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid watch filter %q: %w", p, err)
+			}
+		}
+
+		if exclude {
+			f.exclude = append(f.exclude, p)
+		} else {
+			f.include = append(f.include, p)
+		}
+	}
+
+	return f, nil
+}
+
+// Returns the slash separated path of name relative to the watched
+// path it is in, ok is false if it is a watched path itself or in none of them
+func (f *filter) rel(name string) (rel string, ok bool) {
+	name = filepath.Clean(name)
+	for _, root := range f.roots {
+		r, err := filepath.Rel(root, name)
+		if err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return filepath.ToSlash(r), true
+	}
+
+	return "", false
+}
+
+// Checks if the directory should be watched
+func (f *filter) dir(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok {
+		return true
+	}
+
+	return !matchesAny(f.exclude, rel)
+}
+
+// Checks if changes to the file should trigger a reload
+func (f *filter) file(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok {
+		return true
+	}
+
+	if matchesAny(f.exclude, rel) {
+		return false
+	}
+
+	return len(f.include) == 0 || matchesAny(f.include, rel)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchGlob(strings.Split(p, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches the path segments against the pattern segments using path.Match,
+// where a ** segment matches zero or more path segments
+func matchGlob(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
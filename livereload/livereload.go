@@ -3,7 +3,9 @@ package livereload
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
@@ -12,6 +14,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -22,21 +25,45 @@ import (
 //go:embed liveReloader.html
 var liveReloaderHTML embed.FS
 
-type clientChan chan string
+type clientChan chan event
 
 var (
-	liveServerStarted bool = false
+	liveServerStarted = make(map[*registry.Registry]bool)          // Registries started with RunLiveReload
+	liveReloaders     = make(map[*registry.Registry]*LiveReloader) // The running live reloader of every registry
 	liveServerMu      sync.Mutex
-	clientsMu         sync.Mutex
-	clientsRegister   = make(map[clientChan]struct{})
 )
 
-// Broadcasts a message to all connected clients
-func broadcast(msg string) {
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
+// clients holds the clients connected to one live reloader
+type clients struct {
+	mu       sync.Mutex
+	register map[clientChan]struct{}
+	closed   bool
+}
+
+// Adds the client, returns false if the clients have been closed
+func (c *clients) add(ch clientChan) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.register[ch] = struct{}{}
+	return true
+}
 
-	for ch := range clientsRegister {
+func (c *clients) remove(ch clientChan) {
+	c.mu.Lock()
+	delete(c.register, ch)
+	c.mu.Unlock()
+}
+
+// Broadcasts an event to all connected clients
+func (c *clients) broadcast(msg event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for ch := range c.register {
 		select {
 		case ch <- msg:
 		default:
@@ -44,11 +71,22 @@ func broadcast(msg string) {
 	}
 }
 
-var customReloadHandler func(fsnotify.Event, error)
+// Disconnects all clients and prevents new ones from connecting
+func (c *clients) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for ch := range c.register {
+		close(ch)
+		delete(c.register, ch)
+	}
+}
 
-// Allows custom error handling from outside the livereload package
+// Allows custom error handling from outside the livereload package,
+// notifies the live reloader of the default registry
 func Notify(err error) {
-	customReloadHandler(fsnotify.Event{}, err)
+	registry.Default().Notify(err)
 }
 
 // Helper function for LiveReload to perform logging when a reload occurs
@@ -61,21 +99,83 @@ func HandleReload(e fsnotify.Event, err error) {
 	}
 }
 
-func RunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) (http.HandlerFunc, error) {
+// Config configures a LiveReloader
+type Config struct {
+	Pattern  string                      // The URL path the LiveReloader is registered on in the HTTP server
+	OnReload func(fsnotify.Event, error) // Called on every change and error, if nil HandleReload is used
+	Paths    []string                    // The local paths watched recursively for changes
+	Registry *registry.Registry          // The registry to live reload, if nil registry.Default() is used
+
+	// Glob patterns such as **/*.html or !node_modules/** deciding which paths below
+	// Paths are watched, matched against the slash separated path relative to the
+	// watched path. Patterns prefixed with ! exclude the paths they match. A file is
+	// watched if it matches an include pattern, or there are none, and no exclude pattern.
+	// Excluded directories are not walked. If nil DefaultFilters is used.
+	Filters []string
+
+	// The Watcher reporting the changes. If nil, file system notifications are used and
+	// a polling watcher is used instead if they are not available or fail to watch Paths.
+	// It is closed when the LiveReloader stops, but not if New fails.
+	Watcher      Watcher
+	Poll         bool          // Always uses a polling watcher if Watcher is nil
+	PollInterval time.Duration // The interval of the polling watcher, if 0 DefaultPollInterval is used
+
+	// Makes the injected script only connect with server sent events. By default it connects
+	// over a WebSocket, which works with proxies buffering event streams, and falls back to
+	// server sent events if the WebSocket can not be opened. Both are accepted either way.
+	DisableWebSocket bool
+
+	// Marks the HTML output of SubTemplates with comments, so that it is re-rendered in place
+	// when only their files change instead of reloading the page. Only whole elements served as
+	// text/html are marked, the markers may still affect CSS selectors such as :empty.
+	Fragments bool
+}
 
-	liveServerMu.Lock()
-	defer liveServerMu.Unlock()
+// LiveReloader watches local paths for file changes and notifies the connected
+// clients. While it is running, the templates of its registry are reloaded
+// on render when their files have changed.
+//
+// A LiveReloader is an http.Handler serving the connected clients and must be
+// registered on the Config.Pattern URL path of the HTTP server.
+type LiveReloader struct {
+	id        string // Identifies the server instance to the clients
+	reg       *registry.Registry
+	connected *clients
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   chan struct{} // Closed once the watcher has stopped
+	closed    chan struct{} // Closed once Close has completed
+}
 
-	if liveServerStarted {
-		return nil, errors.New("live reload is already running")
-	} else {
-		liveServerStarted = true
+var ErrAlreadyRunning = errors.New("live reload is already running")
+
+// The data of the injected liveReloader.html script
+type scriptData struct {
+	Pattern   string // The URL path to connect to
+	WebSocket bool   // If true, a WebSocket is tried before server sent events
+}
+
+// Creates and starts a LiveReloader watching config.Paths and
+// the validated local sources of the registry.
+//
+// Only one LiveReloader can run per registry at a time. It runs until ctx is done
+// or Close is called, process signals are not handled.
+func New(ctx context.Context, config Config) (*LiveReloader, error) {
+	reg := config.Registry
+	if reg == nil {
+		reg = registry.Default()
 	}
 
-	if handleReload == nil {
-		customReloadHandler = HandleReload
-	} else {
-		customReloadHandler = handleReload
+	onReload := config.OnReload
+	if onReload == nil {
+		onReload = HandleReload
+	}
+
+	liveServerMu.Lock()
+	defer liveServerMu.Unlock()
+
+	if liveReloaders[reg] != nil {
+		return nil, ErrAlreadyRunning
 	}
 
 	// Parse the live reloader
@@ -84,93 +184,279 @@ func RunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error
 		return nil, err
 	}
 	var buf bytes.Buffer
-	err = t.Execute(&buf, template.JS(handlePattern))
+	err = t.Execute(&buf, scriptData{Pattern: config.Pattern, WebSocket: !config.DisableWebSocket})
 	if err != nil {
 		return nil, err
 	}
-	registry.SetJSToInject(buf.Bytes())
 
-	// New watcher instance
-	watcher, err := fsnotify.NewWatcher()
+	// The local sources of the registry are watched as well, they are validated
+	// here as they are only read while live reloading
+	config.Paths = slices.Clone(config.Paths)
+	for _, source := range reg.LocalSources() {
+		err := source.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		dir := filepath.Clean(source.LocalDir())
+		if !slices.ContainsFunc(config.Paths, func(p string) bool { return filepath.Clean(p) == dir }) {
+			config.Paths = append(config.Paths, dir)
+		}
+	}
+
+	filters := config.Filters
+	if filters == nil {
+		filters = DefaultFilters
+	}
+	f, err := newFilter(config.Paths, filters)
 	if err != nil {
 		return nil, err
 	}
 
-	// Recursively adds directories to the watcher
-	err = walkDirsAndAddPaths(watcher, pathsToWatch)
+	id, err := newInstanceID()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	// Handle interrupt signal to gracefully shutdown the watcher
-	// and forward the signal to the main process
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	watcher, err := newWatcher(config, f, onReload)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	lr := &LiveReloader{
+		id:        id,
+		reg:       reg,
+		connected: &clients{register: make(map[clientChan]struct{})},
+		ctx:       ctx,
+		cancel:    cancel,
+		stopped:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	liveReloaders[reg] = lr
+	reg.OnReset(func() { lr.Close() })
+
+	reg.SetJSToInject(buf.Bytes())
+	reg.SetNotify(func(err error) {
+		onReload(fsnotify.Event{}, err)
+
+		// Show the error on all the connected clients
+		e, err := errorEvent(NewErrorReport(err))
+		if err != nil {
+			onReload(fsnotify.Event{}, err)
+			return
+		}
+		lr.connected.broadcast(e)
+	})
+
 	go func() {
-		sig := <-c
-		cancel()
-		signal.Stop(c)
-		close(c)
-		signal.Reset(os.Interrupt)
-		p, _ := os.FindProcess(os.Getpid())
-		_ = p.Signal(sig)
+		defer close(lr.stopped)
+		runWatcher(ctx, reg, lr.connected, watcher, f, onReload)
 	}()
+	go lr.shutdown()
+
+	// Register live reloading with the validator
+	reg.SetMarkFragments(config.Fragments)
+	reg.SetLiveReload(true)
 
-	go runWatcher(ctx, watcher, customReloadHandler)
+	return lr, nil
+}
 
-	// Build up the HTTP handler function
-	handlerFunc := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// Creates a random ID for a LiveReloader. Clients reconnecting to a LiveReloader
+// with a different ID reload the page, as the server has been restarted.
+func newInstanceID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
 
-		// Register the current client
-		broadcastChannel := make(clientChan, 1)
-		clientsMu.Lock()
-		clientsRegister[broadcastChannel] = struct{}{}
-		clientsMu.Unlock()
+// The event sent to a client when it connects
+func (lr *LiveReloader) connectedEvent() event {
+	return event{eventConnected, connectedData{lr.id}}
+}
 
-		// Unregister the client
-		defer func() {
-			clientsMu.Lock()
-			delete(clientsRegister, broadcastChannel)
-			clientsMu.Unlock()
-		}()
+// Waits for the LiveReloader to be stopped and releases its registry
+func (lr *LiveReloader) shutdown() {
+	<-lr.ctx.Done()
+	<-lr.stopped
 
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+	lr.connected.close()
 
-		// Notify the client of the live server start
-		w.Write([]byte("data: live server is running\n\n"))
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
+	liveServerMu.Lock()
+	if liveReloaders[lr.reg] == lr {
+		delete(liveReloaders, lr.reg)
+		lr.reg.SetLiveReload(false)
+		lr.reg.SetMarkFragments(false)
+		lr.reg.SetNotify(nil)
+		lr.reg.SetJSToInject(nil)
+	}
+	liveServerMu.Unlock()
 
-		// Listen for events from the broadcast channel, client requests, or context cancellation
-		for {
-			select {
-			case msg := <-broadcastChannel:
-				w.Write([]byte(msg))
-				if f, ok := w.(http.Flusher); ok {
-					f.Flush()
-				}
-			case <-r.Context().Done():
-				return
-			case <-ctx.Done():
+	close(lr.closed)
+}
+
+// Stops watching for changes, disconnects all clients and disables
+// live reloading for the registry. Close is safe to call multiple times.
+func (lr *LiveReloader) Close() error {
+	lr.cancel()
+	<-lr.closed
+	return nil
+}
+
+// Serves the events to a connected client until it disconnects or the LiveReloader
+// is closed. WebSocket upgrade requests are served over a WebSocket, all other
+// requests as server sent events.
+func (lr *LiveReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isWebSocketUpgrade(r) {
+		lr.serveWebSocket(w, r)
+		return
+	}
+
+	// Register the current client
+	broadcastChannel := make(clientChan, 8)
+	if !lr.connected.add(broadcastChannel) {
+		http.Error(w, ErrClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Unregister the client
+	defer lr.connected.remove(broadcastChannel)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Notify the client of the live server start
+	w.Write(lr.connectedEvent().sse())
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	// Listen for events from the broadcast channel or client requests
+	// until the LiveReloader is closed
+	for {
+		select {
+		case e, ok := <-broadcastChannel:
+			if !ok {
 				return
 			}
+			w.Write(e.sse())
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		case <-r.Context().Done():
+			return
 		}
+	}
+}
+
+var ErrClosed = errors.New("live reload has been closed")
+
+// Runs live reloading for the default registry, see RunLiveReloadWithRegistry
+func RunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) (http.HandlerFunc, error) {
+	return RunLiveReloadWithRegistry(registry.Default(), handlePattern, handleReload, pathsToWatch...)
+}
+
+// Watches the pathsToWatch and enables live reloading for the templates of reg.
+// Live reload can only be started once per registry.
+//
+// The live reloader runs until the process receives an interrupt signal, which is
+// forwarded to the process once the watcher has stopped. Use New for a LiveReloader
+// which can be stopped and does not handle signals.
+func RunLiveReloadWithRegistry(reg *registry.Registry, handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) (http.HandlerFunc, error) {
 
+	liveServerMu.Lock()
+	if liveServerStarted[reg] {
+		liveServerMu.Unlock()
+		return nil, ErrAlreadyRunning
+	} else {
+		liveServerStarted[reg] = true
+	}
+	liveServerMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lr, err := New(ctx, Config{
+		Pattern:  handlePattern,
+		OnReload: handleReload,
+		Paths:    pathsToWatch,
+		Registry: reg,
 	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
 
-	// Register live reloading with the validator
-	registry.SetLiveReload(true)
+	// Resetting the registry closes the live reloader, after which it can be started again
+	reg.OnReset(func() {
+		liveServerMu.Lock()
+		delete(liveServerStarted, reg)
+		liveServerMu.Unlock()
+	})
+
+	// Handle interrupt signal to gracefully shutdown the watcher
+	// and forward the signal to the main process
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		select {
+		case sig := <-c:
+			cancel()
+			signal.Stop(c)
+			signal.Reset(os.Interrupt)
+			p, _ := os.FindProcess(os.Getpid())
+			_ = p.Signal(sig)
+		case <-lr.closed:
+			cancel()
+			signal.Stop(c)
+		}
+	}()
+
+	return lr.ServeHTTP, nil
+}
+
+// Creates the watcher for the config and adds the watched paths to it.
+// Falls back to a polling watcher if file system notifications are not available,
+// such as on network mounts or when the inotify watch limit has been reached.
+//
+// On failure only the watchers created here are closed, Config.Watcher is owned by the caller.
+func newWatcher(config Config, f *filter, onReload func(fsnotify.Event, error)) (Watcher, error) {
+	if config.Watcher != nil {
+		err := walkDirsAndAddPaths(config.Watcher, f, config.Paths)
+		if err != nil {
+			return nil, err
+		}
+		return config.Watcher, nil
+	}
+
+	if !config.Poll {
+		watcher, err := NewNotifyWatcher()
+		if err == nil {
+			err = walkDirsAndAddPaths(watcher, f, config.Paths)
+			if err == nil {
+				return watcher, nil
+			}
+			watcher.Close()
+		}
+		onReload(fsnotify.Event{}, fmt.Errorf("file system notifications failed, falling back to polling: %w", err))
+	}
+
+	watcher := NewPollingWatcher(config.PollInterval)
+	err := walkDirsAndAddPaths(watcher, f, config.Paths)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
 
-	return handlerFunc, nil
+	return watcher, nil
 }
 
 // fsnotify does not support recursive directory watching,
 // so we need to walk through the directories and add them to the watcher manually.
-func walkDirsAndAddPaths(watcher *fsnotify.Watcher, pathsToWatch []string) error {
+// Directories excluded by the filter are skipped together with their contents.
+func walkDirsAndAddPaths(watcher Watcher, f *filter, pathsToWatch []string) error {
 	for _, path := range pathsToWatch {
 		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
@@ -179,6 +465,10 @@ func walkDirsAndAddPaths(watcher *fsnotify.Watcher, pathsToWatch []string) error
 
 			// If it's a directory, add it to the watcher
 			if d.IsDir() {
+				if !f.dir(path) {
+					return filepath.SkipDir
+				}
+
 				err := watcher.Add(path)
 				if err != nil {
 					return err
@@ -197,30 +487,36 @@ func walkDirsAndAddPaths(watcher *fsnotify.Watcher, pathsToWatch []string) error
 
 }
 
-const goType = ".go"
-
-// The runWatcher function listens for file system events, debounces
-// them to avoid multiple notifications for the same file change, and
-// broadcasts changes to all connected clients
-func runWatcher(ctx context.Context, watcher *fsnotify.Watcher, handleChange func(fsnotify.Event, error)) {
+// The runWatcher function listens for file system events, batches
+// them to avoid multiple notifications for the same change, and
+// broadcasts the changed paths to all connected clients.
+// Changes to paths excluded by the filter are ignored.
+// If only templates which can be re-rendered in place are affected, the
+// re-rendered fragments are broadcast instead.
+func runWatcher(ctx context.Context, reg *registry.Registry, connected *clients, watcher Watcher, f *filter, handleChange func(fsnotify.Event, error)) {
 	var (
 		batchDelay = 100 * time.Millisecond // Delay for batching events
 		batchTimer *time.Timer
+		batchDone  <-chan time.Time
+		batch      []fsnotify.Event // The last event of every changed path, in order of change
 	)
 
 	defer watcher.Close()
 	for {
 		select {
 		case <-ctx.Done():
+			if batchTimer != nil {
+				batchTimer.Stop()
+			}
 			return
-		case event, ok := <-watcher.Events:
+		case event, ok := <-watcher.Events():
 			if !ok {
 				return
 			}
 
-			// ignore Go files, as they are not relevant for live reloading
-			eventNameLength := len(event.Name)
-			if (eventNameLength > 0) && (event.Name[eventNameLength-len(goType):eventNameLength] == goType) {
+			// Excluded paths are ignored before they are stat'ed, as short lived
+			// files such as editor swap files may already have been removed
+			if !f.dir(event.Name) {
 				continue
 			}
 
@@ -233,34 +529,57 @@ func runWatcher(ctx context.Context, watcher *fsnotify.Watcher, handleChange fun
 					continue
 				}
 
-				if fi.IsDir() && len(fi.Name()) != 0 {
-					// Ignore dotfiles
-					if (len(event.Name) > 0) && (event.Name[0] != '.') {
-						walkDirsAndAddPaths(watcher, []string{event.Name})
-					}
+				if fi.IsDir() {
+					walkDirsAndAddPaths(watcher, f, []string{event.Name})
 				}
 			}
 
+			if !f.file(event.Name) {
+				continue
+			}
+
+			batch = slices.DeleteFunc(batch, func(e fsnotify.Event) bool {
+				return e.Name == event.Name
+			})
+			batch = append(batch, event)
+
 			// Avoid multiple notifications for the same file change
 			if batchTimer != nil {
 				batchTimer.Stop()
 			}
+			batchTimer = time.NewTimer(batchDelay)
+			batchDone = batchTimer.C
+		case <-batchDone:
+			batchDone = nil
+
+			paths := make([]string, 0, len(batch))
+			for _, e := range batch {
+				handleChange(e, nil)
+				paths = append(paths, e.Name)
+			}
+			batch = nil
 
-			batchTimer = time.AfterFunc(batchDelay, func() {
-				handleChange(event, nil)
+			// Templates reload on their next render
+			reg.Invalidate()
 
-				// Trigger a reload event
-				broadcast("data: reload\n\n")
-			})
-		case err, ok := <-watcher.Errors:
+			// Trigger a reload, stylesheet swap or fragment swap
+			e := changeEvent(paths)
+			if e.name == eventReload {
+				if fragments, ok := reg.Fragments(paths); ok {
+					e = event{eventFragments, fragmentsData{fragments}}
+				}
+			}
+			connected.broadcast(e)
+		case err, ok := <-watcher.Errors():
 			if !ok {
 				return
 			}
 
 			handleChange(fsnotify.Event{}, err)
 
-			// Trigger a reload event
-			broadcast(fmt.Sprintf("data: live reload error: %s\n\n", err.Error()))
+			connected.broadcast(event{eventWatchError, struct {
+				Error string `json:"error"`
+			}{err.Error()}})
 		}
 	}
 }
@@ -0,0 +1,225 @@
+package livereload
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports changes to the entries of the directories added to it.
+// Directories are not watched recursively, the live reloader adds every
+// directory below the watched paths itself.
+type Watcher interface {
+	Add(dir string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error // Stops the watcher and closes the Events and Errors channels
+}
+
+// Creates a Watcher using the file system notifications of the OS
+func NewNotifyWatcher() (Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return notifyWatcher{w}, nil
+}
+
+type notifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func (n notifyWatcher) Add(dir string) error          { return n.w.Add(dir) }
+func (n notifyWatcher) Events() <-chan fsnotify.Event { return n.w.Events }
+func (n notifyWatcher) Errors() <-chan error          { return n.w.Errors }
+func (n notifyWatcher) Close() error                  { return n.w.Close() }
+
+// The interval used by the polling watcher if Config.PollInterval is not set
+const DefaultPollInterval = 500 * time.Millisecond
+
+// Creates a Watcher which lists the added directories every interval and
+// compares the modification time and size of their entries with the previous listing.
+//
+// It works where file system notifications are not available, such as on network
+// mounts, but changes are reported up to interval late.
+func NewPollingWatcher(interval time.Duration) Watcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	p := &pollingWatcher{
+		interval: interval,
+		dirs:     make(map[string]map[string]fileState),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+
+	return p
+}
+
+// The state of a directory entry compared between listings,
+// directories are only compared by their existence
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+type pollingWatcher struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	dirs map[string]map[string]fileState // Entries of every watched directory by their path
+
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *pollingWatcher) Add(dir string) error {
+	entries, err := list(dir)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.dirs[filepath.Clean(dir)] = entries
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *pollingWatcher) Events() <-chan fsnotify.Event { return p.events }
+func (p *pollingWatcher) Errors() <-chan error          { return p.errors }
+
+func (p *pollingWatcher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		<-p.stopped
+		close(p.events)
+		close(p.errors)
+	})
+	return nil
+}
+
+// Polls the watched directories until the watcher is closed
+func (p *pollingWatcher) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		events, errs := p.poll()
+		for _, e := range events {
+			select {
+			case p.events <- e:
+			case <-p.done:
+				return
+			}
+		}
+		for _, err := range errs {
+			select {
+			case p.errors <- err:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// Lists the watched directories and returns the changes since the last poll.
+// Directories which can no longer be listed are removed from the watcher,
+// the errors are returned unless the directories have been removed.
+func (p *pollingWatcher) poll() ([]fsnotify.Event, []error) {
+	p.mu.Lock()
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	p.mu.Unlock()
+
+	var events []fsnotify.Event
+	var errs []error
+	for _, dir := range dirs {
+		entries, err := list(dir)
+
+		p.mu.Lock()
+		previous, ok := p.dirs[dir]
+		if !ok {
+			p.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			delete(p.dirs, dir)
+		} else {
+			p.dirs[dir] = entries
+		}
+		p.mu.Unlock()
+
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("polling %s: %w", dir, err))
+			continue
+		}
+
+		for name, prev := range previous {
+			current, ok := entries[name]
+			switch {
+			case !ok:
+				events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			case current != prev:
+				events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Write})
+			}
+		}
+		for name := range entries {
+			if _, ok := previous[name]; !ok {
+				events = append(events, fsnotify.Event{Name: name, Op: fsnotify.Create})
+			}
+		}
+	}
+
+	return events, errs
+}
+
+// Returns the state of the entries in dir by their path
+func list(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			states[filepath.Join(dir, entry.Name())] = fileState{}
+			continue
+		}
+		states[filepath.Join(dir, entry.Name())] = fileState{fi.ModTime(), fi.Size()}
+	}
+
+	return states, nil
+}
@@ -0,0 +1,232 @@
+package livereload
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The WebSocket opcodes, see RFC 6455 section 5.2
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// Appended to the client key to create the accept key of the handshake
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The largest frame accepted from a client, clients only send control frames
+const maxClientFrame = 1 << 16
+
+var errFrameTooLarge = errors.New("websocket frame too large")
+
+// Checks if the request asks to upgrade the connection to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the Sec-WebSocket-Accept value for the Sec-WebSocket-Key of the client
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Cross origin pages are not allowed to connect, as unlike EventSource
+// WebSockets are not restricted by CORS and the events may contain source code
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrades the connection to a WebSocket and sends the events to the client
+// as JSON text messages until it disconnects or the LiveReloader is closed
+func (lr *LiveReloader) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket handshake", http.StatusBadRequest)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross origin websocket connections are not allowed", http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket connections are not supported by the server", http.StatusInternalServerError)
+		return
+	}
+
+	// Register the current client
+	broadcastChannel := make(clientChan, 8)
+	if !lr.connected.add(broadcastChannel) {
+		http.Error(w, ErrClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Unregister the client
+	defer lr.connected.remove(broadcastChannel)
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ws := &webSocket{conn: conn, w: rw.Writer}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	err = ws.writeFrame(opText, lr.connectedEvent().json())
+	if err != nil {
+		return
+	}
+
+	// Reads the frames of the client to answer pings and detect disconnects
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		ws.readFrames(rw.Reader)
+	}()
+
+	for {
+		select {
+		case e, ok := <-broadcastChannel:
+			if !ok {
+				ws.writeFrame(opClose, []byte{0x03, 0xE9}) // 1001, going away
+				return
+			}
+			err := ws.writeFrame(opText, e.json())
+			if err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+// webSocket is the server side of a WebSocket connection
+type webSocket struct {
+	conn net.Conn
+	mu   sync.Mutex // Guards writing frames, as pongs are written by the reader
+	w    *bufio.Writer
+}
+
+// Writes an unfragmented and, as sent by the server, unmasked frame
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	ws.w.Write(header)
+	ws.w.Write(payload)
+	return ws.w.Flush()
+}
+
+// Reads the frames sent by the client until it closes the connection or
+// an error occurs. Pings are answered, all other messages are discarded.
+func (ws *webSocket) readFrames(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case opClose:
+			ws.writeFrame(opClose, payload)
+			return
+		case opPing:
+			ws.writeFrame(opPong, payload)
+		}
+	}
+}
+
+// Reads a single frame and unmasks its payload
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(r, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxClientFrame {
+		return 0, nil, errFrameTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(r, mask[:])
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return opcode, payload, nil
+}
@@ -1,6 +1,7 @@
 package loadr
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/fsnotify/fsnotify"
@@ -8,30 +9,113 @@ import (
 	"github.com/nesbyte/loadr/registry"
 )
 
-// Loads and validates all the created templates.
+// Registry holds the templates created from the TemplateContexts bound to it
+// together with their live reload state.
+//
+// All TemplateContexts are bound to a default registry unless SetRegistry is used,
+// the package level LoadTemplates and RunLiveReload functions operate on it.
+// Separate registries allow multiple apps in one binary and parallel tests.
+type Registry struct {
+	r *registry.Registry
+}
+
+// Creates a new, empty registry which TemplateContexts can be bound to with SetRegistry
+func NewRegistry() *Registry {
+	return &Registry{registry.New()}
+}
+
+var defaultRegistry = &Registry{registry.Default()}
+
+// LiveReloader watches local paths for file changes and notifies the connected
+// clients, it is created with NewLiveReload and stopped with Close.
+type LiveReloader = livereload.LiveReloader
+
+// LiveReloadConfig configures a LiveReloader. The Registry field is set by NewLiveReload.
+type LiveReloadConfig = livereload.Config
+
+// Loads and validates all the templates created in the registry.
 // It must be called after all the templates and settings have been created
-func LoadTemplates() error {
-	return registry.LoadTemplates()
+//
+// Every template is loaded even if some fail, the returned error joins
+// all the TemplateErrors in the order the templates were created.
+func (r *Registry) LoadTemplates() error {
+	return r.r.LoadTemplates()
+}
+
+// Sets the maximum number of templates which are loaded concurrently by LoadTemplates,
+// by default templates are loaded serially.
+//
+// When loading concurrently, functions set with SetOnTemplateLoad
+// must be safe for concurrent use.
+func (r *Registry) SetLoadConcurrency(n int) *Registry {
+	r.r.SetLoadConcurrency(n)
+	return r
 }
 
 // The same as RunLiveReload but panics if an error occurs
-func MustRunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) http.HandlerFunc {
-	h, err := RunLiveReload(handlePattern, handleReload, pathsToWatch...)
+func (r *Registry) MustRunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) http.HandlerFunc {
+	h, err := r.RunLiveReload(handlePattern, handleReload, pathsToWatch...)
 	if err != nil {
 		panic(err)
 	}
 	return h
 }
 
+// The same as the package level RunLiveReload, but only enables live
+// reloading for the templates in the registry.
+//
+// Live reload can only be started once per registry.
+func (r *Registry) RunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) (http.HandlerFunc, error) {
+	return livereload.RunLiveReloadWithRegistry(r.r, handlePattern, handleReload, pathsToWatch...)
+}
+
+// The same as the package level NewLiveReload, but only enables live
+// reloading for the templates in the registry.
+func (r *Registry) NewLiveReload(ctx context.Context, config LiveReloadConfig) (*LiveReloader, error) {
+	config.Registry = r.r
+	return livereload.New(ctx, config)
+}
+
+// Loads and validates all the created templates.
+// It must be called after all the templates and settings have been created
+func LoadTemplates() error {
+	return defaultRegistry.LoadTemplates()
+}
+
+// Sets the maximum number of templates which are loaded concurrently
+// by LoadTemplates, see (*Registry).SetLoadConcurrency
+func SetLoadConcurrency(n int) {
+	defaultRegistry.SetLoadConcurrency(n)
+}
+
+// The same as RunLiveReload but panics if an error occurs
+func MustRunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) http.HandlerFunc {
+	return defaultRegistry.MustRunLiveReload(handlePattern, handleReload, pathsToWatch...)
+}
+
 // Watches the specified local pathsToWatch for file changes and notifies connected clients
 // and handleChange if provided.
 //
-// Live reload can only be started once.
+// Live reload can only be started once. It runs until the process receives an interrupt signal,
+// use NewLiveReload for a live reloader which can be stopped.
 //
 // The handlePattern is the URL path that the live server will handle and must match the
 // registered pattern in the HTTP server.
 // handleReload is an optional function that will be called when a file change is detected
 // and can be used for custom logging. If nil is provided a default logging function will be used.
 func RunLiveReload(handlePattern string, handleReload func(fsnotify.Event, error), pathsToWatch ...string) (http.HandlerFunc, error) {
-	return livereload.RunLiveReload(handlePattern, handleReload, pathsToWatch...)
+	return defaultRegistry.RunLiveReload(handlePattern, handleReload, pathsToWatch...)
+}
+
+// Creates and starts a LiveReloader which watches the config.Paths for file changes
+// and notifies the connected clients.
+//
+// Unlike RunLiveReload it does not handle process signals and runs until ctx is done
+// or Close is called, after which live reloading is disabled and it can be created again.
+// Only one LiveReloader can run at a time.
+//
+// The returned LiveReloader is the http.Handler which must be registered on
+// the config.Pattern URL path of the HTTP server.
+func NewLiveReload(ctx context.Context, config LiveReloadConfig) (*LiveReloader, error) {
+	return defaultRegistry.NewLiveReload(ctx, config)
 }
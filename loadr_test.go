@@ -1,16 +1,34 @@
 package loadr
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/nesbyte/loadr/livereload"
 	"github.com/nesbyte/loadr/registry"
 )
 
@@ -18,6 +36,11 @@ const case1Dir = "./testdata/case1"
 const case2Dir = "./testdata/case2"
 const case3Dir = "./testdata/case3"
 const case4Dir = "./testdata/case4"
+const case5Dir = "./testdata/case5"
+const case6Dir = "./testdata/case6"
+const case7Dir = "./testdata/case7"
+const case8Dir = "./testdata/case8"
+const case9Dir = "./testdata/case9"
 
 type case1BaseData struct {
 	Title string
@@ -298,6 +321,17 @@ func TestLiveReloadCallTwice(t *testing.T) {
 		t.Error("want error, live reload cannot be called twice")
 	}
 
+	// Resetting the registry stops the live reloader and allows it to be started again
+	registry.Reset()
+	if registry.LiveReload() {
+		t.Error("want live reloading to be disabled after a reset")
+	}
+
+	_, err = RunLiveReload("/live-reload", nil, "testdata")
+	if err != nil {
+		t.Errorf("want live reload to start after a reset, got: %s", err)
+	}
+	registry.Reset()
 }
 
 // Validates that the FuncMap functionality works as expected
@@ -376,3 +410,1858 @@ func TestSetConfig(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// Validates that RenderE returns execution and write errors
+// instead of discarding them
+func TestRenderEReturnsErrors(t *testing.T) {
+	var (
+		caseFS = os.DirFS(case3Dir)
+	)
+
+	errFail := errors.New("simulated func error")
+	funcMap := template.FuncMap{
+		"toUpper": func(s string) (string, error) {
+			if s == "fail" {
+				return "", errFail
+			}
+			return strings.ToUpper(s), nil
+		},
+	}
+
+	type upperData struct {
+		Name string
+	}
+
+	defer registry.Reset()
+	base := NewTemplateContext(BaseConfig{FS: caseFS}, NoData, "input.html").Funcs(funcMap)
+	index := NewTemplate(base, upperData{"test"})
+	sub := NewSubTemplate(base, "input.html", BaseData[int, upperData]{D: upperData{"test"}})
+
+	err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = index.RenderE(&buf, upperData{"test"})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if buf.String() != "TEST" {
+		t.Errorf("want: TEST\ngot: %s\n", buf.String())
+	}
+
+	err = index.RenderE(&buf, upperData{"fail"})
+	if !errors.Is(err, ErrTemplateExecute) || !errors.Is(err, errFail) {
+		t.Errorf("want error wrapping %q and %q, got: %v", ErrTemplateExecute, errFail, err)
+	}
+	var te TemplateError
+	if !errors.As(err, &te) {
+		t.Errorf("want TemplateError, got: %T", err)
+	}
+
+	err = sub.RenderE(&alwaysFailWriter{}, BaseData[int, upperData]{D: upperData{"test"}})
+	if !errors.Is(err, ErrTemplateExecute) || !errors.Is(err, errorSimulatedWrite) {
+		t.Errorf("want error wrapping %q and %q, got: %v", ErrTemplateExecute, errorSimulatedWrite, err)
+	}
+}
+
+// Validates that buffered rendering never writes partial output
+// and falls back to the error template on failure
+func TestBufferedRenderWithErrorTemplate(t *testing.T) {
+	var (
+		caseFS = os.DirFS(case5Dir)
+	)
+
+	errFail := errors.New("simulated func error")
+	funcMap := template.FuncMap{
+		"check": func(s string) (string, error) {
+			if s == "fail" {
+				return "", errFail
+			}
+			return s, nil
+		},
+	}
+
+	type nameData struct {
+		Name string
+	}
+
+	defer registry.Reset()
+	base := NewTemplateContext(BaseConfig{FS: caseFS}, NoData, "input.html", "error.html").Funcs(funcMap)
+	index := NewTemplate(base, nameData{})
+	base.SetBuffered(true)
+
+	err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = index.RenderE(&buf, nameData{"fail"})
+	if !errors.Is(err, errFail) {
+		t.Errorf("want error wrapping %q, got: %v", errFail, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("want no partial output, got: %s", buf.String())
+	}
+
+	base.SetErrorTemplate("error")
+	err = LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	buf.Reset()
+	index.Render(&buf, nameData{"fail"})
+	if buf.String() != "error page" {
+		t.Errorf("want: error page\ngot: %s\n", buf.String())
+	}
+
+	buf.Reset()
+	index.Render(&buf, nameData{"ok"})
+	if buf.String() != "<p>ok</p>" {
+		t.Errorf("want: <p>ok</p>\ngot: %s\n", buf.String())
+	}
+
+	// An error template which does not exist must fail on load
+	base.SetErrorTemplate("missing")
+	err = LoadTemplates()
+	if !errors.Is(err, ErrTemplateExecute) {
+		t.Errorf("want error wrapping %q, got: %v", ErrTemplateExecute, err)
+	}
+}
+
+// Validates that templates bound to separate registries
+// are loaded independently from each other and the default registry
+func TestRegistryIsolation(t *testing.T) {
+	t.Parallel()
+
+	good := NewRegistry()
+	bad := NewRegistry()
+
+	goodBase := NewTemplateContext(BaseConfig{FS: os.DirFS(case4Dir)}, NoData, "folder/index.html").SetRegistry(good)
+	index := NewTemplate(goodBase, NoData)
+
+	badBase := NewTemplateContext(BaseConfig{FS: os.DirFS("fake")}, NoData, "folder/index.html").SetRegistry(bad)
+	_ = NewTemplate(badBase.WithTemplates("missing.html"), NoData)
+
+	err := good.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	err = bad.LoadTemplates()
+	if !errors.Is(err, ErrTemplateParse) {
+		t.Errorf("want error wrapping %q, got: %v", ErrTemplateParse, err)
+	}
+
+	lr, err := good.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload", Paths: []string{case4Dir}, OnReload: func(fsnotify.Event, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+	if bad.r.LiveReload() {
+		t.Error("live reload should only be enabled for the registry it was run on")
+	}
+
+	var buf bytes.Buffer
+	err = index.RenderE(&buf, NoData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello" {
+		t.Errorf("want: hello\ngot: %s\n", buf.String())
+	}
+}
+
+// Validates that LoadTemplates reports every failing template
+// in registration order
+func TestLoadTemplatesCollectsAllErrors(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case1Dir)}, case1BaseData{}, "input.html").SetRegistry(reg)
+
+	_ = NewTemplate(base.WT("missing1.html"), NoData)
+	_ = NewSubTemplate(base.WT("input.partial1.html"), "partial", case1Partial1{})
+	_ = NewSubTemplate(base.WT("input.partial1.html"), "partial", case1Partial2{})
+	_ = NewTemplate(base.WT("missing2.html"), NoData)
+
+	err := reg.LoadTemplates()
+	if !errors.Is(err, ErrTemplateParse) || !errors.Is(err, ErrTemplateExecute) {
+		t.Fatalf("want error wrapping %q and %q, got: %v", ErrTemplateParse, ErrTemplateExecute, err)
+	}
+
+	var te TemplateError
+	if !errors.As(err, &te) {
+		t.Errorf("want TemplateError, got: %T", err)
+	}
+
+	msg := err.Error()
+	first, second, third := strings.Index(msg, "missing1.html"), strings.Index(msg, "can't evaluate field Sample"), strings.Index(msg, "missing2.html")
+	if first == -1 || second == -1 || third == -1 || first > second || second > third {
+		t.Errorf("want all errors in registration order, got:\n%s", msg)
+	}
+
+	for i := 0; i < 5; i++ {
+		if again := reg.LoadTemplates(); again.Error() != msg {
+			t.Errorf("want deterministic error, got:\n%s\n\nand:\n%s", msg, again)
+		}
+	}
+}
+
+// countingFS counts how often each file has been opened
+type countingFS struct {
+	fs.FS
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.mu.Lock()
+	c.opens[name]++
+	c.mu.Unlock()
+	return c.FS.Open(name)
+}
+
+// Stat avoids fs.Glob opening the files when matching patterns
+func (c *countingFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(c.FS, name)
+}
+
+// Validates that templates load concurrently and identical
+// pattern sets as well as shared base templates are only parsed once
+func TestConcurrentLoadParsesOnce(t *testing.T) {
+	t.Parallel()
+
+	caseFS := &countingFS{FS: os.DirFS(case1Dir), opens: make(map[string]int)}
+	reg := NewRegistry().SetLoadConcurrency(4)
+	base := NewTemplateContext(BaseConfig{FS: caseFS}, case1BaseData{}, "input.html").SetRegistry(reg)
+	p1 := base.WT("input.partial1.html")
+
+	p2 := base.WT("input.partial2.html")
+
+	var templates []*Template[case1BaseData, case1Partial1]
+	for i := 0; i < 20; i++ {
+		templates = append(templates, NewTemplate(p1, case1Partial1{}))
+		_ = NewTemplate(p2, case1Partial2{})
+	}
+
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	// The base templates are shared between the different with templates
+	for _, name := range []string{"input.html", "input.partial1.html", "input.partial2.html"} {
+		if n := caseFS.opens[name]; n != 1 {
+			t.Errorf("want %s to be parsed once, got: %d", name, n)
+		}
+	}
+
+	for _, templ := range templates {
+		var buf bytes.Buffer
+		err = templ.RenderE(&buf, case1Partial1{Sample: "sample"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "<h3>sample</h3>") {
+			t.Errorf("want rendered partial, got: %s", buf.String())
+		}
+	}
+
+	// A new LoadTemplates call must parse the templates again
+	err = reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+	if n := caseFS.opens["input.html"]; n != 2 {
+		t.Errorf("want input.html to be parsed again, got: %d", n)
+	}
+}
+
+// Validates that the source location of parse and execute
+// errors is available on the TemplateError
+func TestTemplateErrorLocation(t *testing.T) {
+	t.Parallel()
+
+	type nameData struct {
+		Name string
+	}
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case6Dir)}, NoData).SetRegistry(reg)
+	_ = NewTemplate(base.Copy().SetBaseTemplates("parse.html"), nameData{})
+	_ = NewTemplate(base.Copy().SetBaseTemplates("pages/exec.html"), nameData{})
+
+	err := reg.LoadTemplates()
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != 2 {
+		t.Fatalf("want 2 errors, got: %v", err)
+	}
+
+	var parseErr, execErr TemplateError
+	if !errors.As(errs[0], &parseErr) || !errors.As(errs[1], &execErr) {
+		t.Fatalf("want TemplateErrors, got: %v", err)
+	}
+
+	if parseErr.File != "parse.html" || parseErr.Line != 2 || parseErr.Column != -1 {
+		t.Errorf("want parse error at parse.html:2 without a column, got: %s:%d:%d", parseErr.File, parseErr.Line, parseErr.Column)
+	}
+
+	if execErr.File != "exec.html" || execErr.Name != "exec.html" || execErr.Line != 3 || execErr.Column != 9 || execErr.Action != ".D.Missing" {
+		t.Errorf("want execute error at exec.html:3:9 in exec.html at .D.Missing, got: %s:%d:%d in %s at %s",
+			execErr.File, execErr.Line, execErr.Column, execErr.Name, execErr.Action)
+	}
+	if execErr.Path() != "pages/exec.html" {
+		t.Errorf("want the path pages/exec.html, got: %s", execErr.Path())
+	}
+	if strings.Join(execErr.Patterns, ",") != "pages/exec.html" {
+		t.Errorf("want patterns pages/exec.html, got: %v", execErr.Patterns)
+	}
+
+	frame, err := execErr.CodeFrame(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "  2 | \t<li>{{.D.Name}}</li>\n" +
+		"> 3 | \t<li>{{.D.Missing}}</li>\n" +
+		"    | \t        ^\n" +
+		"  4 | </ul>\n"
+	if frame != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, frame)
+	}
+
+	// Columns start at 0, unknown columns are not marked
+	execErr.Column = 0
+	frame, _ = execErr.CodeFrame(0)
+	want = "> 3 | \t<li>{{.D.Missing}}</li>\n" +
+		"    | ^\n"
+	if frame != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, frame)
+	}
+
+	frame, _ = parseErr.CodeFrame(0)
+	if strings.Contains(frame, "^") {
+		t.Errorf("want no caret for an unknown column, got:\n%s", frame)
+	}
+}
+
+// Validates that a failing template is replaced by the error
+// overlay when live reloading
+func TestLiveReloadErrorOverlay(t *testing.T) {
+	t.Parallel()
+
+	type nameData struct {
+		Name string
+	}
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case6Dir)}, NoData, "pages/exec.html").SetRegistry(reg)
+	exec := NewTemplate(base, nameData{})
+
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload", Paths: []string{case6Dir}, OnReload: func(fsnotify.Event, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+
+	err = reg.LoadTemplates()
+	if !errors.Is(err, ErrTemplateExecute) {
+		t.Errorf("want error wrapping %q, got: %v", ErrTemplateExecute, err)
+	}
+
+	var buf bytes.Buffer
+	err = exec.RenderE(&buf, nameData{})
+	if !errors.Is(err, ErrTemplateExecute) {
+		t.Errorf("want error wrapping %q, got: %v", ErrTemplateExecute, err)
+	}
+
+	page := buf.String()
+	for _, want := range []string{`id="loadr-error-overlay"`, "pages/exec.html:3:9", "&gt; 3 | \t&lt;li&gt;{{.D.Missing}}&lt;/li&gt;", "<p style=\"margin:0 0 1rem;white-space:pre-wrap;\">template: exec.html:3:9:", "new EventSource("} {
+		if !strings.Contains(page, want) {
+			t.Errorf("want error page containing %q, got:\n%s", want, page)
+		}
+	}
+}
+
+// Validates that changed stylesheets are sent as a css event
+// and any other change as a reload event
+func TestLiveReloadChangeEvents(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry()
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload", Paths: []string{dir}, OnReload: func(fsnotify.Event, error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+	readEvent := connectSSE(t, srv.URL)
+
+	if e := readEvent(); !strings.HasPrefix(e, "event: connected\n") {
+		t.Fatalf("want connected event, got: %s", e)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "style.css"), []byte("body { color: red; }"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: css\n") || !strings.Contains(e, "style.css") {
+		t.Errorf("want css event for style.css, got: %s", e)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "index.html"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: reload\n") || !strings.Contains(e, "index.html") {
+		t.Errorf("want reload event for index.html, got: %s", e)
+	}
+}
+
+// Connects to the live reload handler and returns a function
+// reading the next server sent event
+func connectSSE(t *testing.T, url string) func() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	events := bufio.NewReader(resp.Body)
+	return func() string {
+		var e strings.Builder
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading event failed: %s", err)
+			}
+			if line == "\n" {
+				return e.String()
+			}
+			e.WriteString(line)
+		}
+	}
+}
+
+// Validates that changes to files only used by SubTemplates re-render
+// their live output in place instead of reloading the page
+func TestLiveReloadFragments(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("page.html", `<body>{{.D}}</body>`)
+	write("partial.html", `<b>{{.}}</b>`)
+
+	reg := NewRegistry()
+	page := NewTemplateContext(BaseConfig{FS: os.DirFS(dir)}, NoData, "page.html").SetRegistry(reg)
+	_ = NewTemplate(page, "")
+	partials := NewTemplateContext(BaseConfig{FS: os.DirFS(dir)}, NoData, "partial.html").SetRegistry(reg)
+	partial := NewSubTemplate(partials, "partial.html", 0)
+
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload", Paths: []string{dir}, OnReload: func(fsnotify.Event, error) {}, Fragments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+	readEvent := connectSSE(t, srv.URL)
+	readEvent() // connected
+
+	var buf bytes.Buffer
+	err = partial.RenderE(&buf, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<!--loadr-fragment:(\\d+)--><b>42</b><!--/loadr-fragment:(\\d+)-->"
+	m := regexp.MustCompile(want).FindStringSubmatch(buf.String())
+	if m == nil || m[1] != m[2] {
+		t.Fatalf("want marked fragment, got: %s", buf.String())
+	}
+
+	write("partial.html", `<i>{{.}}</i>`)
+	e := readEvent()
+	if !strings.HasPrefix(e, "event: fragments\n") || !strings.Contains(e, `"target":"`+m[1]+`"`) || !strings.Contains(e, `\u003ci\u003e42\u003c/i\u003e`) {
+		t.Errorf("want fragments event re-rendering target %s, got: %s", m[1], e)
+	}
+
+	write("page.html", `<body><p>{{.D}}</p></body>`)
+	if e := readEvent(); !strings.HasPrefix(e, "event: reload\n") {
+		t.Errorf("want reload event for page.html, got: %s", e)
+	}
+}
+
+// Validates that a LiveReloader can be closed, disconnecting
+// its clients and disabling live reloading
+func TestLiveReloaderClose(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	config := LiveReloadConfig{Pattern: "/live-reload", OnReload: func(fsnotify.Event, error) {}, Paths: []string{case4Dir}}
+	lr, err := reg.NewLiveReload(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reg.NewLiveReload(context.Background(), config)
+	if err == nil {
+		t.Error("want error, only one live reloader can run per registry")
+	}
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !reg.r.LiveReload() {
+		t.Error("want live reloading enabled")
+	}
+
+	err = lr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.r.LiveReload() {
+		t.Error("want live reloading disabled after close")
+	}
+
+	// The stream must end once the client has been disconnected
+	b, err := io.ReadAll(resp.Body)
+	connected := regexp.MustCompile(`^event: connected\ndata: {"id":"([0-9a-f]{16})"}\n\n$`)
+	m := connected.FindStringSubmatch(string(b))
+	if err != nil || m == nil {
+		t.Fatalf("want the stream to end after the connected event, got: %q, %v", b, err)
+	}
+
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("want closed live reloader to refuse clients, got status: %d", resp.StatusCode)
+	}
+
+	// Closing the context stops the new live reloader as well
+	ctx, cancel = context.WithCancel(context.Background())
+	lr, err = reg.NewLiveReload(ctx, config)
+	if err != nil {
+		t.Fatalf("want live reloader to be created again after close, got: %s", err)
+	}
+
+	// Clients reload the page when the ID changes, as the server has restarted
+	restarted := httptest.NewServer(lr)
+	t.Cleanup(restarted.Close)
+	e := connectSSE(t, restarted.URL)()
+	if !strings.HasPrefix(e, "event: connected\n") || strings.Contains(e, m[1]) {
+		t.Errorf("want connected event with a new id, got: %s", e)
+	}
+	cancel()
+	lr.Close()
+	if reg.r.LiveReload() {
+		t.Error("want live reloading disabled after the context is done")
+	}
+}
+
+func TestLiveReloadFilters(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "vendor"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry()
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{
+		Pattern:  "/live-reload",
+		OnReload: func(fsnotify.Event, error) {},
+		Paths:    []string{dir},
+		Filters:  []string{"**/*.html", "!vendor/**"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lr.Close() })
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+	readEvent := connectSSE(t, srv.URL)
+
+	if e := readEvent(); !strings.HasPrefix(e, "event: connected\n") {
+		t.Fatalf("want connected event, got: %s", e)
+	}
+
+	// Only index.html is included, the other changes must not be part of the reload
+	for _, name := range []string{"notes.txt", "index.html~", filepath.Join("vendor", "lib.html"), "index.html"} {
+		err = os.WriteFile(filepath.Join(dir, name), []byte("hello"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := readEvent()
+	if !strings.HasPrefix(e, "event: reload\n") || !strings.Contains(e, "index.html\"") {
+		t.Errorf("want reload event for index.html, got: %s", e)
+	}
+	for _, excluded := range []string{"notes.txt", "index.html~", "lib.html"} {
+		if strings.Contains(e, excluded) {
+			t.Errorf("want %s to be filtered, got: %s", excluded, e)
+		}
+	}
+
+	_, err = NewRegistry().NewLiveReload(context.Background(), LiveReloadConfig{Paths: []string{dir}, Filters: []string{"[*.html"}})
+	if !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("want path.ErrBadPattern for a malformed filter, got: %v", err)
+	}
+}
+
+func TestLiveReloadPollingWatcher(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry()
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{
+		Pattern:      "/live-reload",
+		OnReload:     func(fsnotify.Event, error) {},
+		Paths:        []string{dir},
+		Poll:         true,
+		PollInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lr.Close() })
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+	readEvent := connectSSE(t, srv.URL)
+
+	if e := readEvent(); !strings.HasPrefix(e, "event: connected\n") {
+		t.Fatalf("want connected event, got: %s", e)
+	}
+
+	// The size changes, as the modification time may not change within the poll interval
+	err = os.WriteFile(filepath.Join(dir, "style.css"), []byte("body { color: red; }"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: css\n") || !strings.Contains(e, "style.css") {
+		t.Errorf("want css event for style.css, got: %s", e)
+	}
+
+	err = os.Mkdir(filepath.Join(dir, "pages"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: reload\n") || !strings.Contains(e, "pages") {
+		t.Errorf("want reload event for the new directory, got: %s", e)
+	}
+
+	// Files in created directories are watched
+	err = os.WriteFile(filepath.Join(dir, "pages", "index.html"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: reload\n") || !strings.Contains(e, "index.html") {
+		t.Errorf("want reload event for pages/index.html, got: %s", e)
+	}
+}
+
+// Validates that live reloading only parses templates again
+// when their sources or the watched files have changed
+func TestLiveReloadParsesOnChange(t *testing.T) {
+	t.Parallel()
+
+	mapFS := fstest.MapFS{"item.html": {Data: []byte(`{{define "item"}}<li>{{.}}</li>{{end}}`), ModTime: time.Unix(1, 0)}}
+	caseFS := &countingFS{FS: mapFS, opens: make(map[string]int)}
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: caseFS}, NoData, "item.html").SetRegistry(reg)
+	item := NewSubTemplate(tc, "item", "")
+	reg.r.SetLiveReload(true)
+
+	render := func(wantOpens int, want string) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := item.RenderE(&buf, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %s, got: %s", want, buf.String())
+		}
+		if n := caseFS.opens["item.html"]; n != wantOpens {
+			t.Errorf("want item.html to be parsed %d times, got: %d", wantOpens, n)
+		}
+	}
+
+	render(1, "<li>a</li>")
+	render(1, "<li>a</li>")
+
+	mapFS["item.html"] = &fstest.MapFile{Data: []byte(`{{define "item"}}<p>{{.}}</p>{{end}}`), ModTime: time.Unix(2, 0)}
+	render(2, "<p>a</p>")
+	render(2, "<p>a</p>")
+
+	// Changes seen by the live reloader always reload the templates
+	reg.r.Invalidate()
+	render(3, "<p>a</p>")
+}
+
+func TestLiveReloadWebSocket(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	reg := NewRegistry()
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{
+		Pattern:  "/live-reload",
+		OnReload: func(fsnotify.Event, error) {},
+		Paths:    []string{dir},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lr.Close() })
+
+	if js := reg.r.JSToInject(); !regexp.MustCompile(`if \(\s*true\s*&& "WebSocket" in window\)`).MatchString(js) || !strings.Contains(js, "new EventSource(") {
+		t.Errorf("want WebSocket with EventSource fallback injected, got: %s", js)
+	}
+
+	sseOnly := NewRegistry()
+	sseLR, err := sseOnly.NewLiveReload(context.Background(), LiveReloadConfig{Paths: []string{dir}, OnReload: func(fsnotify.Event, error) {}, DisableWebSocket: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if js := sseOnly.r.JSToInject(); !regexp.MustCompile(`if \(\s*false\s*&& "WebSocket" in window\)`).MatchString(js) {
+		t.Errorf("want only EventSource when the WebSocket is disabled, got: %s", js)
+	}
+	sseLR.Close()
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+
+	// Cross origin pages must not be able to read the events
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("want status %d for a cross origin connection, got: %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	readMessage := connectWebSocket(t, srv.Listener.Addr().String())
+	if m := readMessage(); !regexp.MustCompile(`^{"event":"connected","data":{"id":"[0-9a-f]{16}"}}$`).MatchString(m) {
+		t.Fatalf("want connected message, got: %s", m)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "index.html"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := readMessage(); !strings.HasPrefix(m, `{"event":"reload"`) || !strings.Contains(m, "index.html") {
+		t.Errorf("want reload message for index.html, got: %s", m)
+	}
+}
+
+// Performs the WebSocket handshake with the live reload handler and
+// returns a function reading the next text message
+func connectWebSocket(t *testing.T, addr string) func() string {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The key and accept value are the example of RFC 6455
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/live-reload", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "http://"+addr)
+	err = req.Write(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want status %d, got: %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("want accept key of the RFC example, got: %s", accept)
+	}
+
+	return func() string {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			t.Fatalf("reading frame failed: %s", err)
+		}
+		if opcode := header[0] & 0x0F; opcode != 0x1 {
+			t.Fatalf("want text frame, got opcode: %d", opcode)
+		}
+
+		length := int(header[1] & 0x7F)
+		if length == 126 {
+			ext := make([]byte, 2)
+			_, err = io.ReadFull(r, ext)
+			if err != nil {
+				t.Fatalf("reading frame failed: %s", err)
+			}
+			length = int(ext[0])<<8 | int(ext[1])
+		}
+
+		payload := make([]byte, length)
+		_, err = io.ReadFull(r, payload)
+		if err != nil {
+			t.Fatalf("reading frame failed: %s", err)
+		}
+		return string(payload)
+	}
+}
+
+func TestSourceFS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "item.html"), []byte(`{{define "item"}}<p>source {{.}}</p>{{end}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := fstest.MapFS{"item.html": {Data: []byte(`{{define "item"}}<p>embedded {{.}}</p>{{end}}`)}}
+
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: reg.SourceFS(embedded, dir)}, NoData, "item.html").SetRegistry(reg)
+	item := NewSubTemplate(tc, "item", "")
+	err = reg.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(want string) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := item.RenderE(&buf, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %s, got: %s", want, buf.String())
+		}
+	}
+
+	render("<p>embedded a</p>")
+
+	// The source directory is read and watched while live reloading
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload", OnReload: func(fsnotify.Event, error) {}, Fragments: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lr.Close() })
+
+	srv := httptest.NewServer(lr)
+	t.Cleanup(srv.Close)
+	readEvent := connectSSE(t, srv.URL)
+	if e := readEvent(); !strings.HasPrefix(e, "event: connected\n") {
+		t.Fatalf("want connected event, got: %s", e)
+	}
+
+	render("<p>source a</p>")
+
+	err = os.WriteFile(filepath.Join(dir, "item.html"), []byte(`{{define "item"}}<p>edited {{.}}</p>{{end}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := readEvent(); !strings.HasPrefix(e, "event: fragments\n") || !strings.Contains(e, "edited a") {
+		t.Errorf("want fragments event for item.html, got: %s", e)
+	}
+
+	// Once live reloading stops, loading the templates again reads the embedded files
+	lr.Close()
+	err = reg.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	render("<p>embedded a</p>")
+
+	// Live reloading fails to start if the source directory is missing embedded files
+	reg = NewRegistry()
+	reg.SourceFS(fstest.MapFS{"item.html": {}, "pages/missing.html": {}}, dir)
+	_, err = reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload"})
+	if !errors.Is(err, ErrSourceMismatch) || !strings.Contains(err.Error(), "pages/missing.html") || strings.Contains(err.Error(), "item.html") {
+		t.Errorf("want ErrSourceMismatch for pages/missing.html, got: %v", err)
+	}
+}
+
+func TestSourceFSRegistryOfContext(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "item.html"), []byte(`{{define "item"}}<p>source {{.}}</p>{{end}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := fstest.MapFS{"item.html": {Data: []byte(`{{define "item"}}<p>embedded {{.}}</p>{{end}}`)}}
+
+	// The package level SourceFS switches with the registry of the context using it
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: SourceFS(embedded, dir)}, NoData, "item.html").SetRegistry(reg)
+	item := NewSubTemplate(tc, "item", "")
+	err = reg.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	render := func(want string) {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := item.RenderE(&buf, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %s, got: %s", want, buf.String())
+		}
+	}
+
+	render("<p>embedded a</p>")
+
+	lr, err := reg.NewLiveReload(context.Background(), LiveReloadConfig{Pattern: "/live-reload"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+
+	if defaultRegistry.r.LiveReload() {
+		t.Fatal("want the default registry not to live reload")
+	}
+	if got := defaultRegistry.r.LocalSources(); len(got) != 0 {
+		t.Errorf("want the default registry to not watch the source directory, got: %v", got)
+	}
+	render("<p>source a</p>")
+
+	if got := reg.r.LocalSources(); len(got) != 1 || got[0].LocalDir() != dir {
+		t.Errorf("want the source directory watched by the registry, got: %v", got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	errFail := errors.New("simulated func error")
+	funcMap := template.FuncMap{
+		"check": func(s string) (string, error) {
+			if s == "fail" {
+				return "", errFail
+			}
+			return s, nil
+		},
+	}
+
+	type nameData struct {
+		Name string
+	}
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case5Dir)}, NoData, "input.html", "error.html").Funcs(funcMap).SetRegistry(reg)
+	base.SetErrorTemplate("status")
+	index := NewTemplate(base, nameData{})
+	status := NewSubTemplate(base, "status", ErrorData{})
+	status.SetContentType("text/plain; charset=utf-8")
+
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	serve := func(method string, code int, name string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		err := index.Serve(w, httptest.NewRequest(method, "/", nil), code, nameData{name})
+		return w, err
+	}
+
+	w, err := serve(http.MethodGet, http.StatusCreated, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != "<p>ok</p>" {
+		t.Errorf("want %d <p>ok</p>, got: %d %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if ct, cl := w.Header().Get("Content-Type"), w.Header().Get("Content-Length"); ct != DefaultContentType || cl != "9" {
+		t.Errorf("want Content-Type %q and Content-Length 9, got: %q %q", DefaultContentType, ct, cl)
+	}
+
+	w, err = serve(http.MethodHead, http.StatusOK, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "9" {
+		t.Errorf("want no body and Content-Length 9 for HEAD, got: %q %q", w.Body.String(), w.Header().Get("Content-Length"))
+	}
+
+	// Failures are written with the error template and returned
+	w, err = serve(http.MethodGet, http.StatusOK, "fail")
+	if !errors.Is(err, errFail) || !errors.Is(err, ErrTemplateExecute) {
+		t.Errorf("want error wrapping %q, got: %v", errFail, err)
+	}
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "500 error" {
+		t.Errorf("want %d 500 error, got: %d %s", http.StatusInternalServerError, w.Code, w.Body.String())
+	}
+
+	h := index.Handler(func(r *http.Request) (nameData, error) {
+		if r.URL.Path == "/missing" {
+			return nameData{}, StatusError{http.StatusNotFound, errors.New("no such page")}
+		}
+		return nameData{r.URL.Path}, nil
+	})
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/page", http.StatusOK, "<p>/page</p>"},
+		{"/missing", http.StatusNotFound, "404 error"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.status || w.Body.String() != tc.body {
+			t.Errorf("%s: want %d %s, got: %d %s", tc.path, tc.status, tc.body, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	err = status.Serve(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusTeapot, ErrorData{Status: http.StatusTeapot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); w.Code != http.StatusTeapot || ct != "text/plain; charset=utf-8" || w.Body.String() != "418 error" {
+		t.Errorf("want %d text/plain 418 error, got: %d %s %s", http.StatusTeapot, w.Code, ct, w.Body.String())
+	}
+
+	// Without an error template a plain text error is written
+	base.SetErrorTemplate("")
+	w, err = serve(http.MethodGet, http.StatusOK, "fail")
+	if err == nil || w.Code != http.StatusInternalServerError || w.Body.String() != "Internal Server Error\n" {
+		t.Errorf("want plain text error, got: %d %s %v", w.Code, w.Body.String(), err)
+	}
+}
+
+func TestServeETag(t *testing.T) {
+	t.Parallel()
+
+	type nameData struct {
+		Name string
+	}
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case5Dir)}, NoData, "input.html").Funcs(template.FuncMap{"check": func(s string) string { return s }}).SetRegistry(reg)
+	index := NewTemplate(base, nameData{})
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	serve := func(method string, name string, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		err := index.Serve(w, r, http.StatusOK, nameData{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	if etag := serve(http.MethodGet, "a", "").Header().Get("ETag"); etag != "" {
+		t.Errorf("want no ETag unless enabled, got: %s", etag)
+	}
+
+	index.SetETag(true)
+	w := serve(http.MethodGet, "a", "")
+	etag := w.Header().Get("ETag")
+	if !regexp.MustCompile(`^"[0-9a-f]{32}"$`).MatchString(etag) || w.Body.String() != "<p>a</p>" {
+		t.Fatalf("want strong ETag and body, got: %s %s", etag, w.Body.String())
+	}
+
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		w = serve(http.MethodGet, "a", ifNoneMatch)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: want %d without body, got: %d %s", ifNoneMatch, http.StatusNotModified, w.Code, w.Body.String())
+		}
+	}
+
+	// Changed output gets a new ETag
+	w = serve(http.MethodGet, "b", etag)
+	if w.Code != http.StatusOK || w.Body.String() != "<p>b</p>" || w.Header().Get("ETag") == etag {
+		t.Errorf("want %d with a new ETag, got: %d %s %s", http.StatusOK, w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+
+	w = serve(http.MethodPost, "a", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("want no ETag for POST, got: %d %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// prefixEncoder is a stand in for encoders which are not part of the std lib
+type prefixEncoder string
+
+func (e prefixEncoder) Encoding() string { return string(e) }
+
+func (e prefixEncoder) Encode(w io.Writer, body []byte) error {
+	_, err := fmt.Fprintf(w, "%s:%s", e, body)
+	return err
+}
+
+func TestServeCompression(t *testing.T) {
+	t.Parallel()
+
+	type nameData struct {
+		Name string
+	}
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case5Dir)}, NoData, "input.html").Funcs(template.FuncMap{"check": func(s string) string { return s }}).SetRegistry(reg)
+	base.SetCompression(100, prefixEncoder("br"), GzipEncoder(gzip.BestSpeed))
+	index := NewTemplate(base, nameData{})
+	index.SetETag(true)
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	long := strings.Repeat("a", 200)
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{long, "gzip", "gzip"},
+		{long, "gzip, deflate, br", "br"},
+		{long, "br;q=0, gzip;q=0.5", "gzip"},
+		{long, "*", "br"},
+		{long, "br;q=0, *", "gzip"},
+		{long, "gzip;q=0", ""},
+		{long, "", ""},
+		{"short", "gzip", ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		err := index.Serve(w, r, http.StatusOK, nameData{tc.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h := w.Header()
+		if h.Get("Content-Encoding") != tc.encoding || h.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: want encoding %q with Vary, got: %q %q", tc.acceptEncoding, tc.encoding, h.Get("Content-Encoding"), h.Get("Vary"))
+		}
+		if cl := h.Get("Content-Length"); cl != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("Accept-Encoding %q: want Content-Length %d, got: %s", tc.acceptEncoding, w.Body.Len(), cl)
+		}
+		if tc.encoding != "" && !strings.HasSuffix(h.Get("ETag"), "-"+tc.encoding+`"`) {
+			t.Errorf("Accept-Encoding %q: want ETag for the encoding, got: %s", tc.acceptEncoding, h.Get("ETag"))
+		}
+
+		body := w.Body.String()
+		switch tc.encoding {
+		case "gzip":
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = string(b)
+		case "br":
+			body = strings.TrimPrefix(body, "br:")
+		}
+		if want := "<p>" + tc.name + "</p>"; body != want {
+			t.Errorf("Accept-Encoding %q: want %s, got: %s", tc.acceptEncoding, want, body)
+		}
+	}
+}
+
+type case7PageData struct {
+	Count int
+	Items []string
+}
+
+func TestTemplateFragments(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case7Dir)}, NoData, "page.html").SetRegistry(reg)
+	page := NewTemplate(base, case7PageData{}).AddFragments("counter", "list")
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	data := case7PageData{Count: 3, Items: []string{"a"}}
+	counter := `<span id="counter">3</span>`
+	full := `<html><body>` + counter + `<ul id="list"><li>a</li></ul></body></html>`
+	for _, tc := range []struct {
+		hxRequest string
+		hxTarget  string
+		want      string
+	}{
+		{"", "", full},
+		{"true", "counter", counter},
+		{"true", "unknown", full},
+		{"", "counter", full},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.hxRequest != "" {
+			r.Header.Set("HX-Request", tc.hxRequest)
+		}
+		if tc.hxTarget != "" {
+			r.Header.Set("HX-Target", tc.hxTarget)
+		}
+		err := page.Serve(w, r, http.StatusOK, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(w.Body.String()); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("HX-Request %q HX-Target %q: want %s, got: %s", tc.hxRequest, tc.hxTarget, tc.want, got)
+		}
+		if vary := w.Header().Values("Vary"); !slices.Equal(vary, []string{"HX-Request", "HX-Target"}) {
+			t.Errorf("want Vary HX-Request and HX-Target, got: %q", vary)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = page.RenderFragment(&buf, "list", data)
+	if err != nil || buf.String() != `<ul id="list"><li>a</li></ul>` {
+		t.Errorf("want list fragment, got: %s %v", buf.String(), err)
+	}
+	err = page.RenderFragment(&buf, "broken", data)
+	if !errors.Is(err, ErrUnknownFragment) {
+		t.Errorf("want ErrUnknownFragment, got: %v", err)
+	}
+
+	// Every fragment is validated when loading
+	for _, fragment := range []string{"missing", "broken"} {
+		reg := NewRegistry()
+		base := NewTemplateContext(BaseConfig{FS: os.DirFS(case7Dir)}, NoData, "page.html").SetRegistry(reg)
+		NewTemplate(base, case7PageData{}).AddFragments("counter", fragment)
+		err := reg.LoadTemplates()
+		var te TemplateError
+		if !errors.Is(err, ErrTemplateExecute) || !errors.As(err, &te) || te.usePattern != fragment {
+			t.Errorf("want ErrTemplateExecute for fragment %s, got: %v", fragment, err)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	type itemData struct {
+		Name string
+	}
+
+	errFail := errors.New("simulated func error")
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: os.DirFS(case8Dir)}, NoData, "parts.html").SetRegistry(reg).Funcs(template.FuncMap{
+		"check": func(s string) (string, error) {
+			if s == "fail" {
+				return "", errFail
+			}
+			return s, nil
+		},
+	})
+	count := NewSubTemplate(base, "count", 0)
+	item := NewSubTemplate(base, "item", itemData{})
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err = NewStream(HTMXOOB).
+		Append("", "", item.Part(itemData{"a"})).
+		Append("", "count", count.Part(1)).
+		Append("beforeend", "log", item.Part(itemData{"b"})).
+		Render(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<li>a</li><template><div hx-swap-oob="innerHTML:#count"><b>1</b></div></template><template><div hx-swap-oob="beforeend:#log"><li>b</li></div></template>`
+	if buf.String() != want {
+		t.Errorf("want: %s\ngot: %s", want, buf.String())
+	}
+
+	w := httptest.NewRecorder()
+	err = NewStream(TurboStream).
+		Append("replace", "count", count.Part(2)).
+		Append("remove", "item-1", Part{}).
+		Serve(w, httptest.NewRequest(http.MethodPost, "/", nil), http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<turbo-stream action="replace" target="count"><template><b>2</b></template></turbo-stream><turbo-stream action="remove" target="item-1"></turbo-stream>`
+	if w.Body.String() != want || w.Header().Get("Content-Type") != TurboStreamContentType {
+		t.Errorf("want: %s\ngot: %s %s", want, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	// Failing parts write nothing
+	w = httptest.NewRecorder()
+	err = NewStream(HTMXOOB).
+		Append("", "count", count.Part(3)).
+		Append("", "log", item.Part(itemData{"fail"})).
+		Append("", "log", Part{}).
+		Serve(w, httptest.NewRequest(http.MethodPost, "/", nil), http.StatusOK)
+	if !errors.Is(err, errFail) || !errors.Is(err, ErrEmptyPart) {
+		t.Errorf("want errors of all failed parts, got: %v", err)
+	}
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "<b>3</b>") {
+		t.Errorf("want %d without partial output, got: %d %s", http.StatusInternalServerError, w.Code, w.Body.String())
+	}
+}
+
+// Validates that the htmx out of band envelopes are parsed into the elements htmx swaps
+func TestStreamHTMXOOB(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{"parts.html": {Data: []byte(`{{define "row"}}  <tr class="row"><td>{{.}}</td></tr>{{end}}` +
+		`{{define "cell"}}<td>{{.}}</td>{{end}}{{define "text"}}{{.}}{{end}}` +
+		`{{define "idRow"}}<tr data-a='x > y' ID = "row-{{.}}" hx-swap-oob=innerHTML data-b=a/b/><td>{{.}}</td></tr>{{end}}`)}}
+	reg := NewRegistry()
+	base := NewTemplateContext(BaseConfig{FS: fsys}, NoData, "parts.html").SetRegistry(reg)
+	row := NewSubTemplate(base, "row", "")
+	cell := NewSubTemplate(base, "cell", "")
+	text := NewSubTemplate(base, "text", "")
+	idRow := NewSubTemplate(base, "idRow", "")
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		action, target string
+		part           Part
+		want           string
+	}{
+		// The root element replaces the target, without an element wrapping it
+		{"outerHTML", "row-1", row.Part("a"), `<template>  <tr id="row-1" hx-swap-oob="true" class="row"><td>a</td></tr></template>`},
+		{"true", "row-1", row.Part("a"), `<template>  <tr id="row-1" hx-swap-oob="true" class="row"><td>a</td></tr></template>`},
+		// The own id and hx-swap-oob attributes of the root element are replaced, as duplicates are invalid
+		{"outerHTML", "row-2", idRow.Part("2"), `<template><tr id="row-2" hx-swap-oob="true" data-a='x > y' data-b=a/b/><td>2</td></tr></template>`},
+		// Table contents are swapped from their table parent, as they are dropped within a <div>
+		{"beforeend", "rows", row.Part("b"), `<template><tbody hx-swap-oob="beforeend:#rows">  <tr class="row"><td>b</td></tr></tbody></template>`},
+		{"", "row-1", cell.Part("c"), `<template><tr hx-swap-oob="innerHTML:#row-1"><td>c</td></tr></template>`},
+		{"innerHTML", "title", text.Part("d & e"), `<template><div hx-swap-oob="innerHTML:#title">d &amp; e</div></template>`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := NewStream(HTMXOOB).Append(tt.action, tt.target, tt.part).Render(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s %s: want: %s\ngot: %s", tt.action, tt.target, tt.want, buf.String())
+		}
+	}
+
+	// Replacing the target requires a root element to replace it with
+	err = NewStream(HTMXOOB).Append("outerHTML", "title", text.Part("d")).Render(io.Discard)
+	if !errors.Is(err, ErrNoRootElement) || !strings.Contains(err.Error(), "#title") {
+		t.Errorf("want ErrNoRootElement for #title, got: %v", err)
+	}
+}
+
+type typeCheckItem struct {
+	Name string
+	Tags map[string]string
+}
+
+func (i typeCheckItem) Upper() string {
+	return strings.ToUpper(i.Name)
+}
+
+func (i *typeCheckItem) Ptr() *typeCheckItem {
+	return i
+}
+
+type typeCheckData struct {
+	Show       bool
+	Title      string
+	First      typeCheckItem
+	Items      []typeCheckItem
+	ByKey      map[string]*typeCheckItem
+	Any        any
+	unexported string
+}
+
+func TestTypeCheck(t *testing.T) {
+	t.Parallel()
+
+	type baseData struct {
+		Version string
+	}
+
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: os.DirFS(case9Dir)}, baseData{}, "valid.html").SetRegistry(reg)
+	NewTemplate(tc, typeCheckData{})
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("valid fields in unexecuted branches must pass the type check: %s", err)
+	}
+
+	invalid := NewTemplateContext(BaseConfig{FS: os.DirFS(case9Dir)}, NoData, "invalid.html").SetRegistry(NewRegistry())
+	cases := []struct {
+		pattern string
+		name    string
+		line    int
+		action  string
+		message string
+	}{
+		{"branch", "branch", 2, ".Titel", "can't evaluate field Titel in type loadr.typeCheckData"},
+		{"range", "range", 4, "$item.Nme", "can't evaluate field Nme in type loadr.typeCheckItem"},
+		{"called", "item", 6, ".Name.Length", "can't evaluate field Length in type string"},
+		{"unexported", "unexported", 7, ".unexported", "unexported is an unexported field"},
+		{"pointer", "pointer", 8, ".First.Ptr", "can't evaluate field Ptr in type loadr.typeCheckItem"},
+	}
+
+	for _, c := range cases {
+		err := NewSubTemplate(invalid, c.pattern, typeCheckData{}).Load()
+		if !errors.Is(err, ErrTemplateType) {
+			t.Errorf("%s: want ErrTemplateType, got: %v", c.pattern, err)
+			continue
+		}
+
+		var te TemplateError
+		errors.As(err, &te)
+		if te.File != "invalid.html" || te.Name != c.name || te.Line != c.line || te.Action != c.action {
+			t.Errorf("%s: want %s %s:%d at %s, got: %s %s:%d at %s", c.pattern, c.name, "invalid.html", c.line, c.action, te.Name, te.File, te.Line, te.Action)
+		}
+		if !strings.Contains(err.Error(), c.message) {
+			t.Errorf("%s: want message %q, got: %s", c.pattern, c.message, err)
+		}
+	}
+
+	// The declared type is checked, data declared as an interface is not checked
+	var data any = typeCheckData{}
+	err = NewSubTemplate(invalid, "branch", data).Load()
+	if err != nil {
+		t.Errorf("want data declared as any to not be checked, got: %s", err)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: os.DirFS(case9Dir)}, NoData, "fixtures.html").SetRegistry(reg)
+	valid := typeCheckData{Show: true, Items: []typeCheckItem{{Name: "a"}}, ByKey: map[string]*typeCheckItem{"first": {}}}
+	NewTemplate(tc, typeCheckData{}, valid)
+	NewSubTemplate(tc, "items", typeCheckData{}, valid)
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("valid fixtures must load: %s", err)
+	}
+
+	reg = NewRegistry()
+	tc = NewTemplateContext(BaseConfig{FS: os.DirFS(case9Dir)}, NoData, "fixtures.html").SetRegistry(reg)
+	tmpl := NewTemplate(tc, typeCheckData{},
+		valid,
+		typeCheckData{Show: true},
+		typeCheckData{ByKey: map[string]*typeCheckItem{"first": nil}},
+	)
+	err = tmpl.Load()
+	if !errors.Is(err, ErrTemplateExecute) {
+		t.Fatalf("want ErrTemplateExecute, got: %v", err)
+	}
+
+	// Every failing fixture is reported with its index and location
+	for _, want := range []string{"fixture 1: ", "fixture 2: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q in the error, got: %s", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "fixture 0: ") {
+		t.Errorf("valid fixture must not be reported, got: %s", err)
+	}
+
+	var te TemplateError
+	if !errors.As(err, &te) || te.File != "fixtures.html" || te.Line != 1 {
+		t.Errorf("want the location of the first failing fixture, got: %s:%d", te.File, te.Line)
+	}
+
+	err = NewSubTemplate(tc, "items", typeCheckData{}, valid, typeCheckData{Show: true}).Load()
+	if !errors.Is(err, ErrTemplateExecute) || !strings.Contains(err.Error(), "fixture 1: ") {
+		t.Errorf("want the failing SubTemplate fixture, got: %v", err)
+	}
+}
+
+func TestSyntheticData(t *testing.T) {
+	t.Parallel()
+
+	tc := NewTemplateContext(BaseConfig{FS: os.DirFS(case9Dir)}, NoData, "synthetic.html").
+		SetRegistry(NewRegistry()).
+		Funcs(template.FuncMap{"upper": strings.ToUpper})
+	tags := NewSubTemplate(tc, "tags", typeCheckData{})
+	names := NewSubTemplate(tc, "names", typeCheckData{})
+
+	// The range body is skipped with the zero value
+	err := tags.Load()
+	if err != nil {
+		t.Fatalf("want the zero value to load, got: %s", err)
+	}
+
+	tc.SetSyntheticData(true)
+	err = tags.Load()
+	if !errors.Is(err, ErrTemplateExecute) || !strings.Contains(err.Error(), "synthetic data: ") {
+		t.Errorf("want the range body to fail with synthetic data, got: %v", err)
+	}
+	err = names.Load()
+	if err != nil {
+		t.Errorf("want synthetic data to load, got: %s", err)
+	}
+
+	type node struct {
+		Name     string
+		Next     *node
+		Children []node
+		Index    map[string]int
+		Any      any
+		hidden   string
+	}
+
+	v, ok := syntheticData(reflect.TypeOf(BaseData[int, node]{}))
+	if !ok {
+		t.Fatal("want synthetic data")
+	}
+
+	d := v.(BaseData[int, node])
+	want := BaseData[int, node]{
+		B: 1,
+		D: node{
+			Name:     syntheticString,
+			Next:     &node{},
+			Children: []node{{}},
+			Index:    map[string]int{syntheticString: 1},
+		},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("want: %+v\ngot: %+v", want, d)
+	}
+
+	_, ok = syntheticData(reflect.TypeOf(nil))
+	if ok {
+		t.Error("want no synthetic data for a nil type")
+	}
+}
+
+func TestBufferPoolDropsLargeBuffers(t *testing.T) {
+	t.Parallel()
+
+	large := getBuffer()
+	large.Grow(maxPooledBufferSize + 1)
+	putBuffer(large)
+
+	small := getBuffer()
+	small.WriteString("small")
+	putBuffer(small)
+
+	for i := 0; i < 10; i++ {
+		buf := getBuffer()
+		if buf == large || buf.Cap() > maxPooledBufferSize {
+			t.Fatalf("want buffers larger than %d bytes to be dropped, got one with %d bytes", maxPooledBufferSize, buf.Cap())
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("want pooled buffers to be reset, got: %q", buf.String())
+		}
+		defer putBuffer(buf)
+	}
+}
+
+// Validates that separate TemplateContexts with the same FS and patterns
+// share their parsed templates, but keep their own functions
+func TestParseCacheSharedByRegistry(t *testing.T) {
+	t.Parallel()
+
+	type upperData struct {
+		Name string
+	}
+
+	caseFS := &countingFS{FS: os.DirFS(case3Dir), opens: make(map[string]int)}
+	reg := NewRegistry()
+	upper := NewTemplateContext(BaseConfig{FS: caseFS}, NoData, "input.html").
+		SetRegistry(reg).
+		Funcs(template.FuncMap{"toUpper": strings.ToUpper})
+	lower := NewTemplateContext(BaseConfig{FS: caseFS}, NoData, "input.html").
+		SetRegistry(reg).
+		Funcs(template.FuncMap{"toUpper": strings.ToLower})
+	upperTemplate := NewTemplate(upper, upperData{})
+	lowerTemplate := NewTemplate(lower, upperData{})
+
+	// The same patterns over a different FS must not share the parsed templates
+	otherFS := fstest.MapFS{"input.html": {Data: []byte("other {{.D.Name}}")}}
+	other := NewTemplateContext(BaseConfig{FS: otherFS}, NoData, "input.html").SetRegistry(reg)
+	otherTemplate := NewTemplate(other, upperData{})
+
+	err := reg.LoadTemplates()
+	if err != nil {
+		t.Fatalf("loadtemplates failed: %s", err)
+	}
+
+	if n := caseFS.opens["input.html"]; n != 1 {
+		t.Errorf("want input.html to be parsed once, got: %d", n)
+	}
+	if c := parseCacheOf(reg.r); len(c.entries) != 0 {
+		t.Errorf("want the parsed templates dropped once loaded, got: %d", len(c.entries))
+	}
+
+	for _, c := range []struct {
+		templ *Template[int, upperData]
+		want  string
+	}{
+		{upperTemplate, "TEST"},
+		{lowerTemplate, "test"},
+		{otherTemplate, "other Test"},
+	} {
+		var buf bytes.Buffer
+		err := c.templ.RenderE(&buf, upperData{"Test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.want {
+			t.Errorf("want: %s\ngot: %s", c.want, buf.String())
+		}
+	}
+}
+
+// Validates that only whole HTML elements are marked and only if enabled
+func TestLiveReloadFragmentMarkers(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{"item.html": {Data: []byte(`{{define "item"}}<p>{{.}}</p>{{end}}{{define "text"}}{{.}}{{end}}`)}}
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: fsys}, NoData, "item.html").SetRegistry(reg)
+	item := NewSubTemplate(tc, "item", "")
+	text := NewSubTemplate(tc, "text", "")
+	data := NewSubTemplate(tc, "item", "")
+	data.SetContentType("application/json")
+	reg.r.SetLiveReload(true)
+
+	marked := regexp.MustCompile(`^<!--loadr-fragment:\d+--><p>a</p><!--/loadr-fragment:\d+-->$`)
+	render := func(templ *SubTemplate[string]) string {
+		t.Helper()
+
+		var buf bytes.Buffer
+		err := templ.RenderE(&buf, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	if out := render(item); out != "<p>a</p>" {
+		t.Errorf("want no markers unless enabled, got: %s", out)
+	}
+	if _, ok := item.Fragments(); ok {
+		t.Error("want the page to be reloaded unless markers are enabled")
+	}
+
+	reg.r.SetMarkFragments(true)
+	if out := render(item); !marked.MatchString(out) {
+		t.Errorf("want marked fragment, got: %s", out)
+	}
+	if out := render(text); out != "a" {
+		t.Errorf("want text to not be marked, got: %s", out)
+	}
+	if out := render(data); out != "<p>a</p>" {
+		t.Errorf("want other content types to not be marked, got: %s", out)
+	}
+
+	var buf bytes.Buffer
+	err := NewStream(TurboStream).Append("update", "item", item.Part("a")).Render(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "loadr-fragment") {
+		t.Errorf("want stream parts to not be marked, got: %s", buf.String())
+	}
+}
+
+// Validates that failures while re-rendering fragments are reported like every other error
+func TestLiveReloadFragmentsError(t *testing.T) {
+	t.Parallel()
+
+	errFail := errors.New("simulated func error")
+	fsys := fstest.MapFS{"item.html": {Data: []byte(`{{define "item"}}<p>{{.}}</p>{{end}}`)}}
+	reg := NewRegistry()
+	tc := NewTemplateContext(BaseConfig{FS: fsys}, NoData, "item.html").SetRegistry(reg).Funcs(template.FuncMap{
+		"check": func(s string) (string, error) {
+			if s == "fail" {
+				return "", errFail
+			}
+			return s, nil
+		},
+	})
+	item := NewSubTemplate(tc, "item", "")
+	reg.r.SetLiveReload(true)
+	reg.r.SetMarkFragments(true)
+
+	var notified error
+	reg.r.SetNotify(func(err error) { notified = err })
+
+	for _, data := range []string{"fail", "a"} {
+		err := item.RenderE(io.Discard, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the earlier render fails, the template is reloaded with the latest
+	fsys["item.html"] = &fstest.MapFile{Data: []byte(`{{define "item"}}<p>{{check .}}</p>{{end}}`), ModTime: time.Now()}
+	_, ok := item.Fragments()
+	if !ok {
+		t.Fatal("want fragments to be handled in place")
+	}
+
+	var te TemplateError
+	if !errors.Is(notified, ErrTemplateExecute) || !errors.Is(notified, errFail) || !errors.As(notified, &te) {
+		t.Errorf("want a TemplateError wrapping %q, got: %v", ErrTemplateExecute, notified)
+	}
+}
+
+// stubWatcher fails to add directories and records if it has been closed
+type stubWatcher struct {
+	closed atomic.Bool
+}
+
+func (w *stubWatcher) Add(string) error              { return errors.New("simulated add error") }
+func (w *stubWatcher) Events() <-chan fsnotify.Event { return nil }
+func (w *stubWatcher) Errors() <-chan error          { return nil }
+func (w *stubWatcher) Close() error {
+	w.closed.Store(true)
+	return nil
+}
+
+func TestLiveReloadWatcherErrors(t *testing.T) {
+	t.Parallel()
+
+	// Watchers passed in are owned by the caller if the live reloader fails to start
+	watcher := &stubWatcher{}
+	_, err := NewRegistry().NewLiveReload(context.Background(), LiveReloadConfig{Paths: []string{t.TempDir()}, Watcher: watcher})
+	if err == nil {
+		t.Fatal("want the error of the watcher")
+	}
+	if watcher.closed.Load() {
+		t.Error("want the watcher passed in to not be closed")
+	}
+
+	// Directories which can not be listed are reported by the polling watcher
+	dir := filepath.Join(t.TempDir(), "pages")
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polling := livereload.NewPollingWatcher(100 * time.Millisecond)
+	defer polling.Close()
+	err = polling.Add(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(dir)
+	if err == nil {
+		err = os.WriteFile(dir, []byte("not a directory"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-polling.Errors():
+		if !strings.Contains(err.Error(), dir) {
+			t.Errorf("want error for %s, got: %s", dir, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("want an error for the directory which can not be listed")
+	}
+}
+
+// Validates that handlers created with RunLiveReload connect over a WebSocket as well
+func TestRunLiveReloadWebSocket(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	t.Cleanup(reg.r.Reset)
+
+	h, err := reg.RunLiveReload("/live-reload", func(fsnotify.Event, error) {}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if js := reg.r.JSToInject(); !regexp.MustCompile(`if \(\s*true\s*&& "WebSocket" in window\)`).MatchString(js) {
+		t.Errorf("want the WebSocket to be tried first, got: %s", js)
+	}
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	readMessage := connectWebSocket(t, srv.Listener.Addr().String())
+	if m := readMessage(); !strings.HasPrefix(m, `{"event":"connected"`) {
+		t.Errorf("want connected message, got: %s", m)
+	}
+}
@@ -1,71 +1,373 @@
 package registry
 
 import (
+	"errors"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type Loader interface {
 	Load() error
 }
 
-type registry struct {
-	loaders    map[Loader]struct{}
-	liveReload bool   // If true, sets the Templ to reload on every Render() call
-	jsToInject string // JS to inject at the end of the body
+// Sourcer is implemented by loaders which can report
+// the files they are parsed from
+type Sourcer interface {
+	Sources() []string // Slash separated paths of the files
 }
 
-var store = registry{loaders: make(map[Loader]struct{})}
+// Fragment is the re-rendered output of a loader which
+// replaces its previous output on the page
+type Fragment struct {
+	Target string `json:"target"` // Identifies the previous output on the page
+	HTML   string `json:"html"`
+}
+
+// Fragmenter is implemented by loaders which can re-render
+// their previous output in place
+type Fragmenter interface {
+	// Returns the re-rendered fragments, ok is false if the loader
+	// can not be re-rendered in place and the page must be reloaded instead
+	Fragments() (fragments []Fragment, ok bool)
+}
+
+// LocalSource is implemented by file systems which are read from a local
+// directory while live reloading, the directory is watched by the live reloader
+type LocalSource interface {
+	LocalDir() string // The local directory the files are read from
+	Validate() error  // Checks that the local directory provides the files used otherwise
+}
+
+// Registry holds the registered loaders together with the
+// live reload state used when rendering them.
+//
+// The zero value is not usable, use New instead.
+type Registry struct {
+	mu         sync.Mutex
+	loaders    []Loader            // In registration order
+	registered map[Loader]struct{} // Avoids registering the same loader twice
+	liveReload atomic.Bool         // If true, sets the Templ to reload on every Render() call
+	fragments  atomic.Bool         // If true, the live output of loaders is marked to be re-rendered in place
+	jsToInject string              // JS to inject at the end of the body
+	notify     func(error)         // Called when a template fails to reload
+	sources    []LocalSource       // Watched in addition to the paths of the live reloader
+	resetHooks []func()            // Called by Reset, such as to stop the live reloader
+	values     map[any]any         // Shared by the loaders of the registry, see Value
 
-var mu sync.Mutex
+	loadConcurrency int           // Maximum number of loaders loaded at the same time
+	generation      atomic.Uint64 // Incremented on every LoadTemplates call
+	loading         atomic.Int32  // Number of LoadTemplates calls running
+}
+
+// Creates a new empty registry
+func New() *Registry {
+	return &Registry{registered: make(map[Loader]struct{}), loadConcurrency: 1}
+}
+
+var store = New()
+
+// Returns the default registry used by the package level functions
+func Default() *Registry {
+	return store
+}
 
 // Adds a BaseRender and it's pattern to the register
-func Add(l Loader) {
-	_, ok := store.loaders[l]
-	mu.Lock()
+func (r *Registry) Add(l Loader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.registered[l]; !ok {
+		r.registered[l] = struct{}{}
+		r.loaders = append(r.loaders, l)
+	}
+}
+
+// Adds a function which is called when the registry is Reset, such as to stop
+// the live reloader and release the registry so that it can be started again
+func (r *Registry) OnReset(hook func()) {
+	r.mu.Lock()
+	r.resetHooks = append(r.resetHooks, hook)
+	r.mu.Unlock()
+}
+
+// Returns the value stored for the key, storing the result of create first if there is none.
+// Loaders use it to share state between all loaders of the registry while they are loaded,
+// such as parsed templates. The values are dropped once no LoadTemplates call is running.
+func (r *Registry) Value(key any, create func() any) any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.values[key]
 	if !ok {
-		store.loaders[l] = struct{}{}
+		if r.values == nil {
+			r.values = make(map[any]any)
+		}
+		v = create()
+		r.values[key] = v
 	}
-	mu.Unlock()
+	return v
+}
+
+// Adds a local source which is validated and watched when live reloading starts
+func (r *Registry) AddLocalSource(s LocalSource) {
+	r.mu.Lock()
+	r.sources = append(r.sources, s)
+	r.mu.Unlock()
+}
+
+// Returns the local sources in the order they were added
+func (r *Registry) LocalSources() []LocalSource {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]LocalSource(nil), r.sources...)
 }
 
 // Enables or disables live reloading
-func SetLiveReload(enabled bool) {
-	store.liveReload = enabled
+func (r *Registry) SetLiveReload(enabled bool) {
+	r.liveReload.Store(enabled)
 }
 
 // Checks if live reloading is enabled
-func LiveReload() bool {
-	return store.liveReload
+func (r *Registry) LiveReload() bool {
+	return r.liveReload.Load()
 }
 
-func SetJSToInject(b []byte) {
-	store.jsToInject = string(b)
+func (r *Registry) SetJSToInject(b []byte) {
+	r.mu.Lock()
+	r.jsToInject = string(b)
+	r.mu.Unlock()
 }
 
-func JSToInject() string {
-	return store.jsToInject
+func (r *Registry) JSToInject() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jsToInject
 }
 
-// Prepares the templates by loading and validating them
-func LoadTemplates() error {
+// Enables or disables marking the output of loaders while live reloading,
+// so that it can be re-rendered in place with Fragments
+func (r *Registry) SetMarkFragments(enabled bool) {
+	r.fragments.Store(enabled)
+}
+
+// Checks if the output of loaders is marked while live reloading
+func (r *Registry) MarkFragments() bool {
+	return r.fragments.Load()
+}
+
+// Sets the function called by Notify, usually set by the live reloader
+func (r *Registry) SetNotify(notify func(error)) {
+	r.mu.Lock()
+	r.notify = notify
+	r.mu.Unlock()
+}
+
+// Notifies the live reloader of an error which occurred while reloading.
+// Does nothing if no notify function has been set.
+func (r *Registry) Notify(err error) {
+	r.mu.Lock()
+	notify := r.notify
+	r.mu.Unlock()
+
+	if notify != nil {
+		notify(err)
+	}
+}
+
+// Sets the maximum number of loaders which are loaded concurrently by LoadTemplates.
+// Values below 1 are treated as 1, which loads the templates serially (the default).
+func (r *Registry) SetLoadConcurrency(n int) {
+	r.mu.Lock()
+	r.loadConcurrency = max(n, 1)
+	r.mu.Unlock()
+}
+
+// Checks if LoadTemplates is running, the values of the registry are only kept until it finishes
+func (r *Registry) Loading() bool {
+	return r.loading.Load() > 0
+}
+
+// Returns the number of times LoadTemplates or Invalidate has been called.
+// Loaders can use it to invalidate anything cached during a previous load.
+func (r *Registry) Generation() uint64 {
+	return r.generation.Load()
+}
+
+// Increments the generation without loading the templates, invalidating
+// anything the loaders have cached. Used by the live reloader when files change.
+func (r *Registry) Invalidate() {
+	r.generation.Add(1)
+}
+
+// Prepares the templates by loading and validating them.
+//
+// All loaders are loaded even if some of them fail, the returned error
+// joins every failure in registration order and supports errors.Is and errors.As.
+func (r *Registry) LoadTemplates() error {
+	r.mu.Lock()
+	loaders := append([]Loader(nil), r.loaders...)
+	workers := min(r.loadConcurrency, len(loaders))
+	r.mu.Unlock()
+
+	r.generation.Add(1)
+	r.loading.Add(1)
 
-	for loader := range store.loaders {
-		err := loader.Load()
-		if err != nil {
-			return err
+	// Each worker stores the error at the loader index to keep the registration order
+	errs := make([]error, len(loaders))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = loaders[i].Load()
+			}
+		}()
+	}
+
+	for i := range loaders {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	// The values are only shared while loading, so that they are not kept for the life of the registry
+	if r.loading.Add(-1) == 0 {
+		r.mu.Lock()
+		r.values = nil
+		r.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
+}
+
+// Re-renders the loaders parsed from any of the changed paths.
+//
+// ok is false if no loader has been affected, or if any of the affected loaders
+// is not a Fragmenter or can not be re-rendered in place, which means the page must be reloaded.
+// The changed paths match a source if they are equal to or end with it.
+func (r *Registry) Fragments(changed []string) (fragments []Fragment, ok bool) {
+	r.mu.Lock()
+	loaders := append([]Loader(nil), r.loaders...)
+	r.mu.Unlock()
+
+	var affected []Fragmenter
+	for _, loader := range loaders {
+		s, isSourcer := loader.(Sourcer)
+		if !isSourcer {
+			return nil, false
+		}
+
+		if !includesAny(s.Sources(), changed) {
+			continue
 		}
+
+		f, isFragmenter := loader.(Fragmenter)
+		if !isFragmenter {
+			return nil, false
+		}
+		affected = append(affected, f)
+	}
+
+	if len(affected) == 0 {
+		return nil, false
+	}
+
+	for _, f := range affected {
+		fs, ok := f.Fragments()
+		if !ok {
+			return nil, false
+		}
+		fragments = append(fragments, fs...)
 	}
 
-	return nil
+	return fragments, true
+}
+
+func includesAny(sources []string, changed []string) bool {
+	for _, c := range changed {
+		c = filepath.ToSlash(filepath.Clean(c))
+		for _, s := range sources {
+			if c == s || strings.HasSuffix(c, "/"+s) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // Should not be used unless you know what you are doing.
 //
-// Resests the store, this is helpful for tests to reset
-// the store if incorrect templates are purposfully provided.
+// Resests the registry, this is helpful for tests to reset
+// the registry if incorrect templates are purposfully provided.
+// A running live reloader of the registry is closed.
 // WARNING: If Reset() is used directly in application logic
 // this can remove existing templates, allow Load to silently
 // pass and create runtime panics.
+func (r *Registry) Reset() {
+	r.mu.Lock()
+	hooks := r.resetHooks
+	r.resetHooks = nil
+	r.mu.Unlock()
+
+	// The hooks are called without the lock, as they usually use the registry
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.loaders = nil
+	r.registered = make(map[Loader]struct{})
+	r.liveReload.Store(false)
+	r.fragments.Store(false)
+	r.jsToInject = ""
+	r.notify = nil
+	r.sources = nil
+	r.values = nil
+	r.loadConcurrency = 1
+}
+
+// Adds the loader to the default registry
+func Add(l Loader) {
+	store.Add(l)
+}
+
+// Enables or disables live reloading of the default registry
+func SetLiveReload(enabled bool) {
+	store.SetLiveReload(enabled)
+}
+
+// Checks if live reloading is enabled for the default registry
+func LiveReload() bool {
+	return store.LiveReload()
+}
+
+func SetJSToInject(b []byte) {
+	store.SetJSToInject(b)
+}
+
+func JSToInject() string {
+	return store.JSToInject()
+}
+
+// Prepares the templates of the default registry by loading and validating them
+func LoadTemplates() error {
+	return store.LoadTemplates()
+}
+
+// Sets the load concurrency of the default registry, see (*Registry).SetLoadConcurrency
+func SetLoadConcurrency(n int) {
+	store.SetLoadConcurrency(n)
+}
+
+// Resets the default registry, see (*Registry).Reset
 func Reset() {
-	store = registry{loaders: make(map[Loader]struct{})}
+	store.Reset()
 }
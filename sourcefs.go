@@ -0,0 +1,145 @@
+package loadr
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nesbyte/loadr/registry"
+)
+
+var ErrSourceMismatch = errors.New("the source directory does not match the embedded files")
+
+// Returns an fs.FS which serves the files of embedded, unless live reloading the registry
+// is enabled, in which case the files are read from the local sourceDir instead. This allows
+// the same BaseConfig to be used in production and while live reloading.
+//
+// The registries of the templates using the FS are bound to it as well once the templates are
+// created or loaded, it reads from sourceDir while live reloading any of them.
+//
+// The sourceDir is the directory embedded was created from, relative to the working directory.
+// It is only accessed once live reloading starts, which fails with ErrSourceMismatch if any
+// embedded file is missing from it, and it is watched by the live reloader in addition to its paths.
+// Templates loaded while live reloading keep the local files until LoadTemplates is called again.
+func (r *Registry) SourceFS(embedded fs.FS, sourceDir string) fs.FS {
+	s := newSourceFS(embedded, sourceDir)
+	s.bind(r.r)
+	return s
+}
+
+// Returns an fs.FS reading from sourceDir while live reloading the registry of any
+// template using it and from embedded otherwise, see (*Registry).SourceFS
+func SourceFS(embedded fs.FS, sourceDir string) fs.FS {
+	return newSourceFS(embedded, sourceDir)
+}
+
+type sourceFS struct {
+	embedded fs.FS
+	dir      string
+	local    fs.FS
+
+	mu   sync.Mutex
+	regs []*registry.Registry // Read from local while any of them is live reloading
+}
+
+var _ registry.LocalSource = (*sourceFS)(nil)
+
+func newSourceFS(embedded fs.FS, sourceDir string) *sourceFS {
+	return &sourceFS{embedded: embedded, dir: sourceDir, local: os.DirFS(sourceDir)}
+}
+
+// Binds fsys to the registry if it has been created with SourceFS, called when
+// templates are created and loaded as the registry of their context is fixed by then
+func bindSourceFS(fsys fs.FS, r *registry.Registry) {
+	if s, ok := fsys.(*sourceFS); ok {
+		s.bind(r)
+	}
+}
+
+// Reads from the source directory while the registry is live reloading,
+// which validates and watches the source directory
+func (s *sourceFS) bind(r *registry.Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.Contains(s.regs, r) {
+		s.regs = append(s.regs, r)
+	}
+	// The sources are cleared when the registry is Reset
+	if !slices.Contains(r.LocalSources(), registry.LocalSource(s)) {
+		r.AddLocalSource(s)
+	}
+}
+
+// Returns the file system to read from
+func (s *sourceFS) current() fs.FS {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.regs {
+		if r.LiveReload() {
+			return s.local
+		}
+	}
+	return s.embedded
+}
+
+func (s *sourceFS) Open(name string) (fs.File, error) {
+	return s.current().Open(name)
+}
+
+func (s *sourceFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(s.current(), name)
+}
+
+func (s *sourceFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(s.current(), name)
+}
+
+func (s *sourceFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.current(), name)
+}
+
+func (s *sourceFS) LocalDir() string {
+	return s.dir
+}
+
+// Checks that every embedded file exists in the source directory. The contents are
+// not compared, as the source files are expected to be edited while live reloading.
+func (s *sourceFS) Validate() error {
+	fi, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSourceMismatch, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%w: %q is not a directory", ErrSourceMismatch, s.dir)
+	}
+
+	var missing []string
+	err = fs.WalkDir(s.embedded, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		_, err = os.Stat(filepath.Join(s.dir, filepath.FromSlash(path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, path)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %q is missing %s", ErrSourceMismatch, s.dir, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package loadr
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// StreamFormat selects the envelope elements the parts of a Stream are wrapped in
+type StreamFormat int
+
+const (
+	// Wraps parts in <template> elements for htmx out of band swaps, see (*Stream).Append
+	HTMXOOB StreamFormat = iota
+	// Wraps parts in <turbo-stream action="action" target="target"> elements for Turbo Streams
+	TurboStream
+)
+
+// The Content-Type of Turbo Stream responses
+const TurboStreamContentType = "text/vnd.turbo-stream.html; charset=utf-8"
+
+var ErrEmptyPart = errors.New("part has not been created with Part")
+var ErrNoRootElement = errors.New("part does not start with an element")
+
+// Part is a template bound to its data, created with (*SubTemplate).Part or
+// (*Template).Part, to be rendered as part of a Stream.
+type Part struct {
+	render func(w io.Writer) error
+}
+
+// Binds the data to the template to render it as part of a Stream
+func (t *SubTemplate[U]) Part(data U) Part {
+	return Part{func(w io.Writer) error {
+		return t.renderOutput(w, t.usePattern, data, false)
+	}}
+}
+
+// Binds the base data and data to the template to render it as part of a Stream
+func (t *Template[T, U]) Part(data U) Part {
+	d := BaseData[T, U]{B: *t.baseData, D: data}
+	return Part{func(w io.Writer) error {
+		return t.renderOutput(w, t.usePattern, d, false)
+	}}
+}
+
+// Stream collects the parts of a response which updates several elements of a page
+// at once, such as htmx out of band swaps or Turbo Streams:
+//
+//	s := loadr.NewStream(loadr.HTMXOOB).
+//		Append("", "", row.Part(rowData)).
+//		Append("innerHTML", "count", count.Part(total))
+//	err := s.Serve(w, r, http.StatusOK)
+//
+// The parts are rendered in order into one buffer, so either the whole response
+// or nothing is written.
+type Stream struct {
+	format StreamFormat
+	parts  []streamPart
+}
+
+type streamPart struct {
+	action string
+	target string
+	part   Part
+}
+
+// Creates an empty Stream wrapping its parts in the envelope of the format
+func NewStream(format StreamFormat) *Stream {
+	return &Stream{format: format}
+}
+
+// Appends the part which updates the element with the id target using the action.
+//
+// For HTMXOOB the action is a swap strategy such as innerHTML, outerHTML or beforeend, an empty
+// action defaults to innerHTML. With outerHTML the root element of the part replaces the target,
+// it is given the id target and hx-swap-oob="true" replacing its own id and hx-swap-oob attributes,
+// hence the part must start with an element or rendering fails with ErrNoRootElement. With any other strategy the part is swapped into the target
+// from a wrapping element which can hold it, such as a <tbody> for table rows. Either is enclosed in
+// a <template>, so that table rows and cells are parsed as they are and htmx removes it after swapping.
+// With an empty target the part is written without an envelope, which is used for the main content
+// or parts with their own hx-swap-oob attribute.
+//
+// For TurboStream the action is a Turbo Stream action such as replace, update, append or remove.
+// The remove action has no content, hence its part may be the zero Part.
+func (s *Stream) Append(action, target string, part Part) *Stream {
+	s.parts = append(s.parts, streamPart{action, target, part})
+	return s
+}
+
+// Returns the Content-Type of the format
+func (s *Stream) ContentType() string {
+	if s.format == TurboStream {
+		return TurboStreamContentType
+	}
+	return DefaultContentType
+}
+
+// Renders all parts wrapped in their envelopes to w. If any part
+// fails nothing is written and the errors of all failed parts are returned.
+func (s *Stream) Render(w io.Writer) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := s.render(buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Renders the stream and writes it as the response with the status, the Content-Type
+// and the Content-Length. If any part fails, a plain text error is written with
+// http.StatusInternalServerError instead and the error is returned.
+func (s *Stream) Serve(w http.ResponseWriter, r *http.Request, status int) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := s.render(buf)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", s.ContentType())
+	if !bodyAllowed(status) {
+		w.WriteHeader(status)
+		return nil
+	}
+
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (s *Stream) render(w io.Writer) error {
+	var errs []error
+	for _, p := range s.parts {
+		action := template.HTMLEscapeString(p.action)
+		target := template.HTMLEscapeString(p.target)
+
+		if s.format == TurboStream && p.action == "remove" {
+			io.WriteString(w, `<turbo-stream action="`+action+`" target="`+target+`"></turbo-stream>`)
+			continue
+		}
+		if p.part.render == nil {
+			errs = append(errs, ErrEmptyPart)
+			continue
+		}
+
+		var err error
+		switch {
+		case s.format == TurboStream:
+			io.WriteString(w, `<turbo-stream action="`+action+`" target="`+target+`"><template>`)
+			err = p.part.render(w)
+			io.WriteString(w, `</template></turbo-stream>`)
+		case p.target != "":
+			err = renderOOB(w, action, target, p.part)
+		default:
+			err = p.part.render(w)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Renders the part as an htmx out of band swap of the target, the action and target are escaped
+func renderOOB(w io.Writer, action, target string, part Part) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := part.render(buf)
+	if err != nil {
+		return err
+	}
+
+	html := buf.String()
+	start := len(html) - len(strings.TrimLeftFunc(html, unicode.IsSpace))
+	name := rootElement(html[start:])
+
+	if action == "outerHTML" || action == "true" {
+		if name == "" {
+			return fmt.Errorf("swapping #%s: %w", target, ErrNoRootElement)
+		}
+		nameEnd := start + 1 + len(name)
+		attrs, end, ok := oobAttrs(html, nameEnd)
+		if !ok {
+			return fmt.Errorf("swapping #%s: %w", target, ErrNoRootElement)
+		}
+		io.WriteString(w, `<template>`+html[:nameEnd]+` id="`+target+`" hx-swap-oob="true"`+attrs+html[end:]+`</template>`)
+		return nil
+	}
+
+	if action == "" {
+		action = "innerHTML"
+	}
+	wrapper := oobWrapper(strings.ToLower(name))
+	io.WriteString(w, `<template><`+wrapper+` hx-swap-oob="`+action+`:#`+target+`">`+html+`</`+wrapper+`></template>`)
+	return nil
+}
+
+// Returns the tag name of the element html starts with, or "" if it does not start with one
+func rootElement(html string) string {
+	if len(html) < 2 || html[0] != '<' || !isASCIILetter(html[1]) {
+		return ""
+	}
+
+	end := strings.IndexFunc(html[1:], func(r rune) bool {
+		return unicode.IsSpace(r) || r == '/' || r == '>'
+	})
+	if end < 0 {
+		return ""
+	}
+	return html[1 : 1+end]
+}
+
+// Returns the attributes of the start tag whose name ends at i without the id and hx-swap-oob
+// attributes, which are replaced, and the index of the closing '>'. ok is false if the tag does not end.
+func oobAttrs(html string, i int) (attrs string, end int, ok bool) {
+	var b strings.Builder
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r' }
+	isSeparator := func(c byte) bool { return isSpace(c) || c == '/' }
+
+	for i < len(html) {
+		// Every attribute is kept together with the whitespace preceding it
+		attrStart := i
+		for i < len(html) && isSeparator(html[i]) {
+			i++
+		}
+		if i >= len(html) {
+			break
+		}
+		if html[i] == '>' {
+			b.WriteString(html[attrStart:i])
+			return b.String(), i, true
+		}
+
+		nameStart := i
+		for i < len(html) && !isSeparator(html[i]) && html[i] != '>' && (html[i] != '=' || i == nameStart) {
+			i++
+		}
+		name := strings.ToLower(html[nameStart:i])
+
+		// The value is optional and may be quoted, with whitespace around the =
+		j := i
+		for j < len(html) && isSpace(html[j]) {
+			j++
+		}
+		if j < len(html) && html[j] == '=' {
+			i = j + 1
+			for i < len(html) && isSpace(html[i]) {
+				i++
+			}
+			if i < len(html) && (html[i] == '"' || html[i] == '\'') {
+				quote := strings.IndexByte(html[i+1:], html[i])
+				if quote < 0 {
+					return "", 0, false
+				}
+				i += quote + 2
+			} else {
+				for i < len(html) && !isSpace(html[i]) && html[i] != '>' {
+					i++
+				}
+			}
+		}
+
+		switch name {
+		case "id", "hx-swap-oob", "data-hx-swap-oob":
+		default:
+			b.WriteString(html[attrStart:i])
+		}
+	}
+
+	return "", 0, false
+}
+
+// Returns the element wrapping swapped content starting with the element name. Table
+// contents are only parsed within their table parent, any other content within a <div>.
+func oobWrapper(name string) string {
+	switch name {
+	case "tr":
+		return "tbody"
+	case "td", "th":
+		return "tr"
+	case "thead", "tbody", "tfoot", "caption", "colgroup":
+		return "table"
+	case "col":
+		return "colgroup"
+	}
+	return "div"
+}
@@ -0,0 +1,85 @@
+package loadr
+
+import "reflect"
+
+// The value of generated strings
+const syntheticString = "synthetic"
+
+// Returns a value of typ populated as described by SetSyntheticData,
+// ok is false if typ is nil, such as for the type of a nil interface
+func syntheticData(typ reflect.Type) (v any, ok bool) {
+	if typ == nil {
+		return nil, false
+	}
+
+	value := reflect.New(typ).Elem()
+	fillSynthetic(value, make(map[reflect.Type]bool))
+	return value.Interface(), true
+}
+
+// Fills the settable value v, the types on the path to v are tracked
+// in seen so that recursive types are left empty once they recur
+func fillSynthetic(v reflect.Value, seen map[reflect.Type]bool) {
+	typ := v.Type()
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(1)
+		return
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+		return
+	case reflect.Complex64, reflect.Complex128:
+		v.SetComplex(1)
+		return
+	case reflect.String:
+		v.SetString(syntheticString)
+		return
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+	default:
+		// Interfaces, functions, channels and unsafe pointers stay nil
+		return
+	}
+
+	if seen[typ] {
+		return
+	}
+	seen[typ] = true
+	defer delete(seen, typ)
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(typ.Elem())
+		fillSynthetic(p.Elem(), seen)
+		v.Set(p)
+	case reflect.Slice:
+		s := reflect.MakeSlice(typ, 1, 1)
+		fillSynthetic(s.Index(0), seen)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillSynthetic(v.Index(i), seen)
+		}
+	case reflect.Map:
+		key := reflect.New(typ.Key()).Elem()
+		fillSynthetic(key, seen)
+		elem := reflect.New(typ.Elem()).Elem()
+		fillSynthetic(elem, seen)
+
+		m := reflect.MakeMapWithSize(typ, 1)
+		m.SetMapIndex(key, elem)
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).IsExported() {
+				fillSynthetic(v.Field(i), seen)
+			}
+		}
+	}
+}
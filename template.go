@@ -6,33 +6,15 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"maps"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"strings"
 
 	"github.com/nesbyte/loadr/livereload"
-	"github.com/nesbyte/loadr/registry"
 )
 
-var ErrTemplateExecute = errors.New("template execute error")
-
-// TemplateError is the error type returned by the template loading and rendering
-// functions. It wraps the underlying error and provides context about the
-// template patterns used.
-type TemplateError struct {
-	ctx        templateContextCore
-	usePattern string
-	Err        error
-}
-
-func (e TemplateError) Error() string {
-	return fmt.Sprintf("basetemplates %q with templates %q and template pattern %q failed: %s", e.ctx.baseTemplates, strings.Join(e.ctx.withTemplates, ", "), e.usePattern, e.Err.Error())
-}
-
-func (e TemplateError) Unwrap() error {
-	return e.Err
-}
-
 type Template[T, U any] struct {
 	SubTemplate[U]
 	baseData *T
@@ -51,17 +33,27 @@ type BaseData[T any, U any] struct {
 // The expected data structure which will be used by the Render(w, data) method should also be provided as it is used
 // for the loading and validation when loadr.LoadTemplates() is called.
 //
+// Optional fixtures are executed as well to validate the branches the data does not take, such as
+// non-empty slices, non-nil pointers or specific enum values. Every failing fixture is reported.
+//
 // No templates get parsed until loadr.Validate() is run
-func NewTemplate[T, U any](tc *TemplateContext[T], data U) *Template[T, U] {
+func NewTemplate[T, U any](tc *TemplateContext[T], data U, fixtures ...U) *Template[T, U] {
 	t := Template[T, U]{
 		SubTemplate: SubTemplate[U]{
-			ctx:  tc.templateContextCore,
-			data: data,
+			ctx:      tc.templateContextCore,
+			data:     data,
+			fixtures: fixtures,
+			dataType: reflect.TypeOf((*BaseData[T, U])(nil)).Elem(),
+			live:     &liveState{},
 		},
 		baseData: tc.baseData,
 	}
+	t.wrapData = func(d U) any {
+		return BaseData[T, U]{B: *t.baseData, D: d}
+	}
 
-	registry.Add(&t)
+	t.ctx.registry.Add(&t)
+	bindSourceFS(t.ctx.config.FS, t.ctx.registry)
 
 	return &t
 }
@@ -73,12 +65,12 @@ var ErrNoBasePatternFound = errors.New("no basetemplate has been provided, but N
 func (t *Template[T, U]) Load() error {
 
 	if len(t.ctx.baseTemplates) == 0 {
-		return TemplateError{t.ctx, t.usePattern, ErrNoBasePatternFound}
+		return newTemplateError(t.ctx, t.usePattern, ErrNoBasePatternFound)
 	}
 	t.usePattern = filepath.Base(t.ctx.baseTemplates[0])
 
 	err := t.load(BaseData[T, U]{B: *t.baseData, D: t.data})
-	if errors.Is(err, ErrTemplateExecute) {
+	if errors.Is(err, ErrTemplateExecute) || errors.Is(err, ErrTemplateType) {
 		return fmt.Errorf("%w: has .B or .D prefix been included for this Template?", err)
 	}
 	return err
@@ -96,7 +88,11 @@ func (t *Template[T, U]) Load() error {
 // Even if no base data has been provided, the template will be provided
 // in the above form. If live reloading is enabled, JS is injected at the end of the body.
 //
-// If handling io.Writer errors or performing compression is required, it is suggested to wrap the io.Writer
+// To handle template execution and io.Writer errors, use RenderE instead.
+// To write HTTP responses with a status and headers, use Serve or Handler.
+//
+// For compressed HTTP responses, use Serve or Handler together with SetCompression.
+// If handling io.Writer errors is required, it is suggested to wrap the io.Writer
 // in a custom writer to add further functionality, for example to get writer errors:
 //
 //	type wrapWriter struct {
@@ -121,26 +117,51 @@ func (t *Template[T, U]) Render(w io.Writer, data U) {
 	t.render(w, d)
 }
 
+// The same as Render, but instead of discarding errors, any error from executing
+// the template or writing to w is returned as a TemplateError wrapping ErrTemplateExecute.
+//
+// Output written before the error occurred is not rolled back.
+func (t *Template[T, U]) RenderE(w io.Writer, data U) error {
+	d := BaseData[T, U]{B: *t.baseData, D: data}
+	return t.renderE(w, t.usePattern, d)
+}
+
 type SubTemplate[U any] struct {
-	t          *template.Template
-	ctx        templateContextCore
-	usePattern string
-	data       U
+	t           *template.Template
+	ctx         templateContextCore
+	usePattern  string
+	data        U
+	fixtures    []U          // Executed in addition to data when loading
+	wrapData    func(U) any  // If set, wraps the fixtures as they are executed, such as in BaseData
+	dataType    reflect.Type // The declared type of the executed data, used by the type check
+	live        *liveState
+	contentType string   // Set by Serve and Handler, if empty DefaultContentType is used
+	etag        bool     // If true, Serve and Handler set an ETag and answer conditional requests
+	fragments   []string // Entry points rendered instead of usePattern for htmx requests
 }
 
 // Similar to NewTemplate, but allows a template to be created
 // that matches the provided pattern. The returned template
 // does not include base data when Render(*,*) is called, hence also does not rely on .B and .D
 //
+// When live reloading with LiveReloadConfig.Fragments set, rendered HTML elements are wrapped in
+// HTML comments so that they can be re-rendered in place when only the files of SubTemplates change.
+//
+// Optional fixtures are executed in addition to the data when loading, see NewTemplate.
+//
 // No templates get parsed until loadr.Validate() is run
-func NewSubTemplate[T, U any](tc *TemplateContext[T], pattern string, data U) *SubTemplate[U] {
+func NewSubTemplate[T, U any](tc *TemplateContext[T], pattern string, data U, fixtures ...U) *SubTemplate[U] {
 	t := SubTemplate[U]{
 		ctx:        tc.templateContextCore,
 		data:       data,
+		fixtures:   fixtures,
 		usePattern: pattern,
+		dataType:   reflect.TypeOf((*U)(nil)).Elem(),
+		live:       &liveState{fragment: true},
 	}
 
-	registry.Add(&t)
+	t.ctx.registry.Add(&t)
+	bindSourceFS(t.ctx.config.FS, t.ctx.registry)
 
 	return &t
 }
@@ -157,10 +178,23 @@ func (t *SubTemplate[U]) Render(w io.Writer, data U) {
 	t.render(w, data)
 }
 
+// The same as Render, but instead of discarding errors, any error from executing
+// the template or writing to w is returned as a TemplateError wrapping ErrTemplateExecute.
+func (t *SubTemplate[U]) RenderE(w io.Writer, data U) error {
+	return t.renderE(w, t.usePattern, data)
+}
+
 var ErrNoConfigProvided = errors.New("no config provided")
 var ErrNoBaseOrPatternFound = errors.New("no basetemplate nor patterns have been provided")
 var ErrTemplateParse = errors.New("template parse error")
 
+// ErrorData is the data passed to the error template set with
+// SetErrorTemplate when a buffered render fails.
+type ErrorData struct {
+	Err    error // The TemplateError that caused the render to fail, or the error of a Handler data function
+	Status int   // The HTTP status code of the response
+}
+
 func (t *SubTemplate[U]) load(data any) error {
 	// Immeditately run on load
 	if t.ctx.onLoad != nil {
@@ -170,6 +204,11 @@ func (t *SubTemplate[U]) load(data any) error {
 		}
 	}
 
+	return t.parse(data)
+}
+
+// Parses and validates the template by executing it with data
+func (t *SubTemplate[U]) parse(data any) error {
 	if t.ctx.config == nil {
 		return ErrNoConfigProvided
 	}
@@ -179,73 +218,251 @@ func (t *SubTemplate[U]) load(data any) error {
 	patterns = append(patterns, t.ctx.withTemplates...)
 
 	if len(patterns) == 0 {
-		return TemplateError{t.ctx, "", ErrNoBaseOrPatternFound}
+		return newTemplateError(t.ctx, "", ErrNoBaseOrPatternFound)
 	}
 
-	// Parse and cache the template
+	// The FS may have been changed with SetConfig since the template was created
+	bindSourceFS(t.ctx.config.FS, t.ctx.registry)
+
+	// Parse and cache the template while LoadTemplates is running
+	// When live reloading, the templates must always be read fresh from the FS
 	var err error
-	t.t, err = template.New("").Funcs(*t.ctx.funcMap).ParseFS(t.ctx.config.FS, patterns...)
+	if t.ctx.registry.LiveReload() || !t.ctx.registry.Loading() {
+		t.t, err = template.New("").Funcs(*t.ctx.funcMap).ParseFS(t.ctx.config.FS, patterns...)
+	} else {
+		t.t, err = parseCacheOf(t.ctx.registry).parse(t.ctx.registry.Generation(), t.ctx.config.FS, *t.ctx.funcMap, t.ctx.baseTemplates, t.ctx.withTemplates)
+	}
 	if err != nil {
-		return TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w: %v", ErrTemplateParse, err)}
+		return newTemplateError(t.ctx, t.usePattern, fmt.Errorf("%w: %w", ErrTemplateParse, err))
 	}
 
 	var buf bytes.Buffer
 	err = t.t.ExecuteTemplate(&buf, t.usePattern, data)
 	if err != nil {
-		return TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w: %v", ErrTemplateExecute, err)}
+		return newTemplateError(t.ctx, t.usePattern, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+	}
+
+	err = t.validateFragments(data)
+	if err != nil {
+		return err
+	}
+
+	err = t.validateFixtures(data)
+	if err != nil {
+		return err
+	}
+
+	// Execution only validates the branches taken with data, the
+	// type check covers the fields and methods used in all of them
+	for _, name := range append([]string{t.usePattern}, t.fragments...) {
+		err = t.typeCheck(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.ctx.render.errorTemplate != "" {
+		buf.Reset()
+		err = t.t.ExecuteTemplate(&buf, t.ctx.render.errorTemplate, ErrorData{Err: newTemplateError(t.ctx, t.usePattern, ErrTemplateExecute), Status: http.StatusInternalServerError})
+		if err != nil {
+			return newTemplateError(t.ctx, t.ctx.render.errorTemplate, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+		}
 	}
 
 	return nil
 
 }
 
-// render is the actual implementation to render the template.
+// Executes the template and its fragments with every fixture and, if enabled, with
+// synthetic data of the type of data. Returns the errors of all failing values joined.
+func (t *SubTemplate[U]) validateFixtures(data any) error {
+	var errs []error
+	for i, fixture := range t.fixtures {
+		var d any = fixture
+		if t.wrapData != nil {
+			d = t.wrapData(fixture)
+		}
+		errs = append(errs, t.validateData(d, fmt.Sprintf("fixture %d", i)))
+	}
+
+	if t.ctx.render.synthetic {
+		if d, ok := syntheticData(reflect.TypeOf(data)); ok {
+			errs = append(errs, t.validateData(d, "synthetic data"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Executes the template and its fragments with d, the error describes d with desc
+func (t *SubTemplate[U]) validateData(d any, desc string) error {
+	for _, name := range append([]string{t.usePattern}, t.fragments...) {
+		err := t.t.ExecuteTemplate(io.Discard, name, d)
+		if err != nil {
+			return newTemplateError(t.ctx, name, fmt.Errorf("%w: %s: %w", ErrTemplateExecute, desc, err))
+		}
+	}
+
+	return nil
+}
+
+// render renders the template and discards all errors except for the
+// ones caused by implementation bugs on the server, which panic.
 func (t *SubTemplate[U]) render(w io.Writer, d any) {
+	err := t.renderE(w, t.usePattern, d)
+
+	// these are edgecase implementation bugs on the server, panic to notify implementation
+	if errors.Is(err, http.ErrBodyNotAllowed) || errors.Is(err, http.ErrHijacked) || errors.Is(err, http.ErrContentLength) {
+		var te TemplateError
+		errors.As(err, &te)
+		panic(&te)
+	}
+}
+
+// renderE is the actual implementation to render the template name,
+// which is the usePattern or one of the fragments.
+func (t *SubTemplate[U]) renderE(w io.Writer, name string, d any) error {
+	return t.renderOutput(w, name, d, true)
+}
+
+// renderOutput renders the template name like renderE. When live reloading, the
+// output is only marked to be re-rendered in place if markable is true.
+func (t *SubTemplate[U]) renderOutput(w io.Writer, name string, d any, markable bool) error {
 
 	// Without reload, rendering is short and simple
-	if !registry.LiveReload() {
-		err := t.t.ExecuteTemplate(w, t.usePattern, d)
-		switch err {
-		// these are edgecase implementation bugs on the server, panic to notify implementation
-		case http.ErrBodyNotAllowed, http.ErrHijacked, http.ErrContentLength:
-			panic(&TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w %s", ErrTemplateExecute, err)})
+	if !t.ctx.registry.LiveReload() {
+		if t.ctx.render.buffered {
+			return t.renderBuffered(w, name, d)
+		}
+
+		err := t.t.ExecuteTemplate(w, name, d)
+		if err != nil {
+			return newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+		}
+
+		return nil
+	}
+
+	// Reload and execute the component under the lock, the output
+	// is written once it has been released to not wait for slow clients
+	var buf bytes.Buffer
+	t.live.mu.Lock()
+	err := t.reload(d)
+	if err == nil {
+		err = t.t.ExecuteTemplate(&buf, name, d)
+		if err != nil {
+			err = newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+		}
+	}
+
+	// Capture the output to a buffer to inject the necessary JS
+	html := buf.String()
+	if err == nil {
+		idx := strings.LastIndex(strings.ToLower(html), "</body>")
+		if idx != -1 {
+			html = html[:idx] + t.ctx.registry.JSToInject() + html[idx:]
+		} else if markable && t.markable(html) {
+			// Partial output can be re-rendered in place when its sources change
+			html = t.live.mark(html, d)
+		}
+	}
+	t.live.mu.Unlock()
+
+	if err != nil {
+		t.ctx.registry.Notify(err)
+
+		// Show the error overlay in place of the template. To allow for SSE to work
+		// even if the template fails to load, the JS is injected to allow for reconnection
+		werr := livereload.WriteErrorPage(w, livereload.NewErrorReport(err), t.ctx.registry.JSToInject())
+		if werr != nil {
+			return newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, werr))
 		}
 
-		return
+		return err
 	}
 
-	// Reload the component
-	err := t.load(d)
+	_, err = w.Write([]byte(html))
 	if err != nil {
-		livereload.Notify(err)
+		return newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+	}
 
-		// To allow for SSE to work even if the template fails to load,
-		// the bare JS must be injected to allow for reconnection
-		_, err := w.Write([]byte(registry.JSToInject()))
+	return nil
+}
+
+// Loads the template like load, but only parses and validates it again if it has
+// never been loaded, its sources have changed since or the live reloader has seen changes.
+// Must be called with t.live.mu held.
+func (t *SubTemplate[U]) reload(data any) error {
+	if t.ctx.onLoad != nil {
+		err := t.ctx.onLoad()
 		if err != nil {
-			panic(&TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w %s", ErrTemplateExecute, err)})
+			return err
 		}
+	}
 
-		return
+	generation := t.ctx.registry.Generation()
+	sources, err := t.statSources()
+	if err == nil && t.t != nil && t.live.sources != nil &&
+		t.live.generation == generation && maps.Equal(sources, t.live.sources) {
+		return nil
 	}
 
-	var buf bytes.Buffer
-	// Capture the output to a buffer to inject the necessary JS
-	err = t.t.ExecuteTemplate(&buf, t.usePattern, d)
+	t.live.sources = nil
+	err = t.parse(data)
 	if err != nil {
-		panic(&TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w %s", ErrTemplateExecute, err)})
+		return err
 	}
 
-	html := buf.String()
-	idx := strings.LastIndex(strings.ToLower(html), "</body>")
-	if idx != -1 {
-		html = html[:idx] + registry.JSToInject() + html[idx:]
+	t.live.sources = sources
+	t.live.generation = generation
+
+	return nil
+}
+
+// renderBuffered executes the template into a pooled buffer and only writes
+// it to w on success. On failure the error template is written instead, if set.
+func (t *SubTemplate[U]) renderBuffered(w io.Writer, name string, d any) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err := t.execute(buf, name, d)
+	_, werr := buf.WriteTo(w)
+	if werr == nil {
+		return err
 	}
 
-	_, err = w.Write([]byte(html))
-	switch err {
-	// these are edgecase implementation bugs on the server, panic to notify implementation
-	case http.ErrBodyNotAllowed, http.ErrHijacked, http.ErrContentLength:
-		panic(&TemplateError{t.ctx, t.usePattern, fmt.Errorf("%w %s", ErrTemplateExecute, err)})
+	if err == nil {
+		return newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, werr))
 	}
+	return errors.Join(err, newTemplateError(t.ctx, t.ctx.render.errorTemplate, fmt.Errorf("%w: %w", ErrTemplateExecute, werr)))
+}
+
+// Executes the template into buf. On failure buf only holds
+// the output of the error template, or nothing if it is not set.
+func (t *SubTemplate[U]) execute(buf *bytes.Buffer, name string, d any) error {
+	err := t.t.ExecuteTemplate(buf, name, d)
+	if err == nil {
+		return nil
+	}
+
+	buf.Reset()
+	execErr := newTemplateError(t.ctx, name, fmt.Errorf("%w: %w", ErrTemplateExecute, err))
+	return t.executeErrorTemplate(buf, execErr, http.StatusInternalServerError)
+}
+
+// Executes the error template, if set, into buf with err and the status. Returns err
+// joined with the error of the error template if it fails, in which case buf is empty.
+func (t *SubTemplate[U]) executeErrorTemplate(buf *bytes.Buffer, err error, status int) error {
+	errorTemplate := t.ctx.render.errorTemplate
+	if errorTemplate == "" || t.t == nil {
+		return err
+	}
+
+	terr := t.t.ExecuteTemplate(buf, errorTemplate, ErrorData{Err: err, Status: status})
+	if terr != nil {
+		buf.Reset()
+		return errors.Join(err, newTemplateError(t.ctx, errorTemplate, fmt.Errorf("%w: %w", ErrTemplateExecute, terr)))
+	}
+
+	return err
 }
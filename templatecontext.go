@@ -3,6 +3,8 @@ package loadr
 import (
 	"html/template"
 	"io/fs"
+
+	"github.com/nesbyte/loadr/registry"
 )
 
 // Creates a new template context acting as a base for any derived templates.
@@ -11,12 +13,16 @@ import (
 //
 // The baseData is used to define the data type passed in to the
 // template for the base data for all child templates.
+//
+// The TemplateContext is bound to the default registry, use SetRegistry to change it.
 func NewTemplateContext[T any](baseConfig BaseConfig, baseData T, basePatterns ...string) *TemplateContext[T] {
 	return &TemplateContext[T]{
 		templateContextCore: templateContextCore{
+			registry:      defaultRegistry.r,
 			config:        &baseConfig,
 			baseTemplates: basePatterns,
 			funcMap:       &template.FuncMap{},
+			render:        &renderConfig{},
 		},
 		baseData: &baseData,
 	}
@@ -33,11 +39,20 @@ type TemplateContext[T any] struct {
 }
 
 type templateContextCore struct {
+	registry      *registry.Registry // The registry templates are added to
 	config        *BaseConfig
 	baseTemplates []string // The base templates that are used and settable
 	withTemplates []string
 	onLoad        func() error      // If set, called before the templates are loaded
 	funcMap       *template.FuncMap // Functions that will be added to the templates
+	render        *renderConfig     // Render behaviour shared by all copies of the context
+}
+
+type renderConfig struct {
+	buffered      bool         // If true, renders into a pooled buffer before writing
+	errorTemplate string       // If set, rendered in place of a failed buffered render
+	compression   *compression // If set, Serve and Handler compress their responses
+	synthetic     bool         // If true, templates are also validated with generated data
 }
 
 // Performs a shallow copy equivalent of TemplateContext
@@ -45,17 +60,19 @@ type templateContextCore struct {
 // are cloned to allow for overriding the templates
 // without changing the original TemplateContext.
 //
-// Changes in the Config and BaseData will propegate
-// to the copied TemplateContext.
+// Changes in the Config, BaseData and render settings (SetBuffered, SetErrorTemplate,
+// SetCompression, SetSyntheticData) will propegate to the copied TemplateContext.
 func (tc *TemplateContext[T]) Copy(patterns ...string) *TemplateContext[T] {
 	bt := append([]string(nil), tc.baseTemplates...)
 	at := append([]string(nil), tc.withTemplates...)
 	newTemplateContext := TemplateContext[T]{
 		templateContextCore: templateContextCore{
+			registry:      tc.registry,
 			config:        tc.config,
 			baseTemplates: bt,
 			withTemplates: at,
 			funcMap:       tc.funcMap,
+			render:        tc.render,
 		},
 		baseData: tc.baseData,
 	}
@@ -64,6 +81,16 @@ func (tc *TemplateContext[T]) Copy(patterns ...string) *TemplateContext[T] {
 
 }
 
+// Binds the TemplateContext to the registry, templates created from it
+// with NewTemplate and NewSubTemplate are added to and loaded by the registry.
+//
+// SetRegistry must be called before any templates are created from the TemplateContext,
+// later copies of the TemplateContext inherit the registry.
+func (tc *TemplateContext[T]) SetRegistry(r *Registry) *TemplateContext[T] {
+	tc.registry = r.r
+	return tc
+}
+
 type BaseConfig struct {
 	FS fs.FS // Sets the FS of the renderer, us fs.Sub to specify root of the FS
 }
@@ -133,3 +160,58 @@ func (tc *TemplateContext[T]) Funcs(funcMap template.FuncMap) *TemplateContext[T
 	*tc.funcMap = funcMap
 	return tc
 }
+
+// Enables or disables buffered rendering for all templates of the TemplateContext.
+//
+// When enabled, Render renders into a pooled buffer and only writes to the io.Writer
+// once the template has executed successfully, so a failing template never leaves
+// the client with partial output. If an error template has been set with SetErrorTemplate,
+// it is written instead.
+//
+// Has no effect when live reloading is enabled, as the output is always buffered then.
+func (tc *TemplateContext[T]) SetBuffered(enabled bool) *TemplateContext[T] {
+	tc.render.buffered = enabled
+	return tc
+}
+
+// Sets the template name which is rendered with ErrorData when a buffered
+// render, Serve or Handler fails. The template must be defined in the base or with templates and
+// is validated when loadr.LoadTemplates() is called.
+//
+// Providing an empty pattern removes the error template.
+func (tc *TemplateContext[T]) SetErrorTemplate(pattern string) *TemplateContext[T] {
+	tc.render.errorTemplate = pattern
+	return tc
+}
+
+// Enables compression of the responses written by Serve and Handler for all templates
+// of the TemplateContext. The first of the encoders accepted by the Accept-Encoding header
+// of the request is used, outputs smaller than minSize bytes are sent uncompressed.
+//
+//	tc.SetCompression(loadr.DefaultMinCompressSize, loadr.GzipEncoder(gzip.DefaultCompression))
+//
+// Calling it without encoders disables compression.
+func (tc *TemplateContext[T]) SetCompression(minSize int, encoders ...Encoder) *TemplateContext[T] {
+	if len(encoders) == 0 {
+		tc.render.compression = nil
+		return tc
+	}
+
+	tc.render.compression = &compression{minSize: minSize, encoders: append([]Encoder(nil), encoders...)}
+	return tc
+}
+
+// Enables or disables validation with synthetic data for all templates of the TemplateContext.
+//
+// When enabled, loading a template also executes it with data generated from the types of the
+// base data and data, in which pointers are non-nil, slices and maps have one element, strings
+// are non-empty, numbers are 1 and bools are true. This exercises the bodies of {{range}}, {{if}}
+// and {{with}} which the zero values skip, without writing fixtures for every template.
+//
+// Unexported fields, interfaces, functions and channels are left as their zero value, as are
+// recursive types once they recur. Templates which expect specific values, such as an index
+// beyond the first element, may fail with the synthetic data and should use fixtures instead.
+func (tc *TemplateContext[T]) SetSyntheticData(enabled bool) *TemplateContext[T] {
+	tc.render.synthetic = enabled
+	return tc
+}
@@ -0,0 +1,207 @@
+package loadr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nesbyte/loadr/livereload"
+)
+
+var ErrTemplateExecute = errors.New("template execute error")
+
+// TemplateError is the error type returned by the template loading and rendering
+// functions. It wraps the underlying error and provides context about the
+// template patterns used.
+//
+// The source location of the error is parsed from the std lib template errors,
+// fields which could not be determined are left empty, or -1 for the Column.
+type TemplateError struct {
+	ctx        templateContextCore
+	usePattern string
+	Err        error
+
+	File     string   // Base name of the failing file as the std lib names templates, see Path for its path
+	Name     string   // Name of the failing template, such as a {{define}} name
+	Line     int      // Line of the failure in File, starting at 1
+	Column   int      // Byte offset of the failure within Line, starting at 0, or -1 if it is unknown
+	Action   string   // The failing action, such as .D.Name
+	Patterns []string // The base and with templates which were parsed
+}
+
+// Matches "template: file:line:col: executing "name" at <action>: " as returned by the executor,
+// or with checking instead of executing as returned by the type check
+var execErrorRe = regexp.MustCompile(`template: ([^:\s]+):(\d+):(\d+): (?:executing|checking) "([^"]*)" at <(.*?)>: `)
+
+// Matches "template: file:line: " as returned by the parser
+var parseErrorRe = regexp.MustCompile(`template: ([^:\s]+):(\d+): `)
+
+// Matches "html/template:file:line:col: " or "html/template:name:line: " as returned by the escaper
+var escapeErrorRe = regexp.MustCompile(`html/template:([^:\s]+):(\d+)(?::(\d+))?: `)
+
+// Creates a TemplateError and fills in the source location of the error where it can be found.
+// The path of the file is only resolved when needed, as errors are created on every failed render.
+func newTemplateError(ctx templateContextCore, usePattern string, err error) TemplateError {
+	e := TemplateError{ctx: ctx, usePattern: usePattern, Err: err, Column: -1}
+	e.Patterns = append(append([]string(nil), ctx.baseTemplates...), ctx.withTemplates...)
+
+	if err == nil {
+		return e
+	}
+
+	msg := err.Error()
+	var htmlErr *template.Error
+	if m := execErrorRe.FindStringSubmatch(msg); m != nil {
+		e.File = m[1]
+		e.Line, _ = strconv.Atoi(m[2])
+		e.Column, _ = strconv.Atoi(m[3])
+		e.Name = m[4]
+		e.Action = m[5]
+	} else if m := parseErrorRe.FindStringSubmatch(msg); m != nil {
+		e.File = m[1]
+		e.Name = m[1]
+		e.Line, _ = strconv.Atoi(m[2])
+	} else if m := escapeErrorRe.FindStringSubmatch(msg); m != nil {
+		e.File = m[1]
+		e.Line, _ = strconv.Atoi(m[2])
+		if m[3] != "" {
+			e.Column, _ = strconv.Atoi(m[3])
+		}
+		if errors.As(err, &htmlErr) {
+			e.Name = htmlErr.Name
+		}
+	}
+
+	return e
+}
+
+// Returns the path of File in BaseConfig.FS. The std lib names templates by the
+// base name of their file, the path is found by matching the patterns against the FS.
+// Returns File if the path could not be resolved.
+func (e TemplateError) Path() string {
+	name := e.File
+	if name == "" || e.ctx.config == nil || e.ctx.config.FS == nil {
+		return name
+	}
+
+	for _, pattern := range e.Patterns {
+		matches, err := fs.Glob(e.ctx.config.FS, pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, match := range matches {
+			if path.Base(match) == name {
+				return match
+			}
+		}
+	}
+
+	return name
+}
+
+func (e TemplateError) Error() string {
+	return fmt.Sprintf("basetemplates %q with templates %q and template pattern %q failed: %s", e.ctx.baseTemplates, strings.Join(e.ctx.withTemplates, ", "), e.usePattern, e.Err.Error())
+}
+
+func (e TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Describes the error for the live reload error overlay
+func (e TemplateError) Report() livereload.ErrorReport {
+	report := livereload.ErrorReport{
+		Title:  fmt.Sprintf("template %q failed", e.usePattern),
+		File:   e.Path(),
+		Line:   e.Line,
+		Column: e.Column,
+	}
+
+	// The innermost error holds the std lib message, the errors are
+	// wrapped as fmt.Errorf("%w: %w", ErrTemplate*, err) hence the last one is followed
+	inner := e.Err
+	for inner != nil {
+		var next error
+		switch u := inner.(type) {
+		case interface{ Unwrap() error }:
+			next = u.Unwrap()
+		case interface{ Unwrap() []error }:
+			if errs := u.Unwrap(); len(errs) > 0 {
+				next = errs[len(errs)-1]
+			}
+		}
+		if next == nil {
+			report.Message = inner.Error()
+			break
+		}
+		inner = next
+	}
+
+	report.CodeFrame, _ = e.CodeFrame(3)
+
+	return report
+}
+
+var ErrNoSourceLocation = errors.New("the error has no source location")
+
+// Renders a snippet of the source around the failing line read from BaseConfig.FS,
+// with contextLines before and after it. The failing line is marked with > and the
+// column, if known, with ^:
+//
+//	  2 | <head>
+//	> 3 |     <title>{{.B.Titel}}</title>
+//	    |                ^
+//	  4 | </head>
+func (e TemplateError) CodeFrame(contextLines int) (string, error) {
+	if e.File == "" || e.Line == 0 || e.ctx.config == nil || e.ctx.config.FS == nil {
+		return "", ErrNoSourceLocation
+	}
+
+	b, err := fs.ReadFile(e.ctx.config.FS, e.Path())
+	if err != nil {
+		return "", err
+	}
+
+	first := max(e.Line-contextLines, 1)
+	last := e.Line + contextLines
+	width := len(strconv.Itoa(last))
+
+	var frame strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan() && n <= last; n++ {
+		if n < first {
+			continue
+		}
+
+		line := scanner.Text()
+		marker := " "
+		if n == e.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&frame, "%s %*d | %s\n", marker, width, n, line)
+
+		if n == e.Line && e.Column >= 0 && e.Column <= len(line) {
+			// Keep tabs so the caret lines up with the source
+			indent := strings.Map(func(r rune) rune {
+				if r == '\t' {
+					return r
+				}
+				return ' '
+			}, line[:e.Column])
+			fmt.Fprintf(&frame, "  %*s | %s^\n", width, "", indent)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return frame.String(), nil
+}
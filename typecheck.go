@@ -0,0 +1,374 @@
+package loadr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template/parse"
+)
+
+var ErrTemplateType = errors.New("template type error")
+
+// Types of the builtin functions whose result type is known
+var builtinResults = map[string]reflect.Type{
+	"not":      reflect.TypeOf(false),
+	"eq":       reflect.TypeOf(false),
+	"ne":       reflect.TypeOf(false),
+	"lt":       reflect.TypeOf(false),
+	"le":       reflect.TypeOf(false),
+	"gt":       reflect.TypeOf(false),
+	"ge":       reflect.TypeOf(false),
+	"len":      reflect.TypeOf(0),
+	"print":    reflect.TypeOf(""),
+	"printf":   reflect.TypeOf(""),
+	"println":  reflect.TypeOf(""),
+	"html":     reflect.TypeOf(""),
+	"js":       reflect.TypeOf(""),
+	"urlquery": reflect.TypeOf(""),
+}
+
+var stringType = reflect.TypeOf("")
+
+// Checks the field and method chains of the template name and every template it calls
+// against the declared type of the data, which catches misspelled fields in branches
+// that are not executed when validating with the data.
+//
+// The checks are only as strict as the types allow, chains on interfaces, such as
+// an any field or data declared as any, and on the results of functions with unknown
+// types are not checked.
+func (t *SubTemplate[U]) typeCheck(name string) error {
+	c := typeChecker{
+		ctx:     t.ctx,
+		name:    name,
+		lookup:  t.lookupTree,
+		funcs:   *t.ctx.funcMap,
+		visited: make(map[typeCheckKey]bool),
+	}
+	c.checkTemplate(name, t.dataType)
+
+	return errors.Join(c.errs...)
+}
+
+type typeCheckKey struct {
+	name string
+	dot  reflect.Type
+}
+
+type typeCheckVar struct {
+	name string
+	typ  reflect.Type
+}
+
+// typeChecker walks the parse trees tracking the type of dot and the variables,
+// a nil reflect.Type means the type is unknown and is not checked further.
+//
+// Addressable values, such as the elements of slices and the fields reached through
+// pointers, are tracked as pointers to their type, as text/template calls the methods
+// with pointer receivers on them like on pointers.
+type typeChecker struct {
+	ctx     templateContextCore
+	name    string // The checked entry point
+	lookup  func(name string) *parse.Tree
+	funcs   map[string]any
+	visited map[typeCheckKey]bool // Templates already checked with the type of dot
+	errs    []error
+
+	tree *parse.Tree
+	vars []typeCheckVar
+}
+
+// Returns the parse tree of the template name. The templates derived by the
+// html/template escaper are only known to text/template, their original is used instead.
+func (t *SubTemplate[U]) lookupTree(name string) *parse.Tree {
+	name, _, _ = strings.Cut(name, "$htmltemplate_")
+	tmpl := t.t.Lookup(name)
+	if tmpl == nil {
+		return nil
+	}
+	return tmpl.Tree
+}
+
+func (c *typeChecker) checkTemplate(name string, dot reflect.Type) {
+	tree := c.lookup(name)
+	if tree == nil || tree.Root == nil {
+		return
+	}
+
+	key := typeCheckKey{tree.Name, dot}
+	if c.visited[key] {
+		return
+	}
+	c.visited[key] = true
+
+	// Templates are called with fresh variables, $ is the data they are called with
+	tree, c.tree = c.tree, tree
+	vars := c.vars
+	c.vars = []typeCheckVar{{"$", dot}}
+
+	c.walk(dot, c.tree.Root)
+
+	c.tree, c.vars = tree, vars
+}
+
+func (c *typeChecker) walk(dot reflect.Type, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, node := range n.Nodes {
+			c.walk(dot, node)
+		}
+	case *parse.ActionNode:
+		// Variables declared by actions stay in scope until the end of the enclosing list,
+		// which is handled by the control structures restoring the variables
+		c.pipe(dot, n.Pipe)
+	case *parse.IfNode:
+		c.branch(dot, &n.BranchNode, false)
+	case *parse.WithNode:
+		c.branch(dot, &n.BranchNode, true)
+	case *parse.RangeNode:
+		c.rangeNode(dot, n)
+	case *parse.TemplateNode:
+		var typ reflect.Type
+		if n.Pipe != nil {
+			typ = c.pipe(dot, n.Pipe)
+		}
+		c.checkTemplate(n.Name, typ)
+	}
+}
+
+// Checks the branches of if and with, with sets dot to the value of the pipeline
+func (c *typeChecker) branch(dot reflect.Type, n *parse.BranchNode, with bool) {
+	mark := len(c.vars)
+	typ := c.pipe(dot, n.Pipe)
+
+	if with {
+		c.walk(typ, n.List)
+	} else {
+		c.walk(dot, n.List)
+	}
+	c.vars = c.vars[:mark]
+
+	c.walk(dot, n.ElseList)
+	c.vars = c.vars[:mark]
+}
+
+func (c *typeChecker) rangeNode(dot reflect.Type, n *parse.RangeNode) {
+	mark := len(c.vars)
+	typ := c.cmds(dot, n.Pipe.Cmds)
+
+	var key, elem reflect.Type
+	if typ != nil {
+		// Ranging indirects pointers, the elements of arrays are addressable through them
+		addr := false
+		for typ.Kind() == reflect.Pointer {
+			typ, addr = typ.Elem(), true
+		}
+
+		switch typ.Kind() {
+		case reflect.Array:
+			key, elem = reflect.TypeOf(0), typ.Elem()
+			if addr {
+				elem = addressable(elem)
+			}
+		case reflect.Slice:
+			key, elem = reflect.TypeOf(0), addressable(typ.Elem())
+		case reflect.Map:
+			key, elem = typ.Key(), typ.Elem()
+		case reflect.Chan:
+			elem = typ.Elem()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			elem = typ
+		}
+	}
+
+	// With one variable it is the element, with two the key and the element
+	switch len(n.Pipe.Decl) {
+	case 1:
+		c.declare(n.Pipe.Decl[0], elem)
+	case 2:
+		c.declare(n.Pipe.Decl[0], key)
+		c.declare(n.Pipe.Decl[1], elem)
+	}
+
+	c.walk(elem, n.List)
+	c.vars = c.vars[:mark]
+
+	c.walk(dot, n.ElseList)
+	c.vars = c.vars[:mark]
+}
+
+// Returns the type of the pipeline and declares or assigns its variables
+func (c *typeChecker) pipe(dot reflect.Type, pipe *parse.PipeNode) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+
+	typ := c.cmds(dot, pipe.Cmds)
+	for _, v := range pipe.Decl {
+		if !pipe.IsAssign {
+			c.declare(v, typ)
+			continue
+		}
+
+		// Assigned variables may hold values of different types
+		for i := len(c.vars) - 1; i >= 0; i-- {
+			if c.vars[i].name == v.Ident[0] {
+				if c.vars[i].typ != typ {
+					c.vars[i].typ = nil
+				}
+				break
+			}
+		}
+	}
+
+	return typ
+}
+
+func (c *typeChecker) declare(v *parse.VariableNode, typ reflect.Type) {
+	c.vars = append(c.vars, typeCheckVar{v.Ident[0], typ})
+}
+
+// Returns the type of the last command, the result of every command is passed to the next
+func (c *typeChecker) cmds(dot reflect.Type, cmds []*parse.CommandNode) reflect.Type {
+	var typ reflect.Type
+	for _, cmd := range cmds {
+		typ = c.cmd(dot, cmd)
+	}
+	return typ
+}
+
+func (c *typeChecker) cmd(dot reflect.Type, cmd *parse.CommandNode) reflect.Type {
+	for _, arg := range cmd.Args[1:] {
+		c.arg(dot, arg)
+	}
+
+	switch n := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		return c.funcResult(n.Ident)
+	default:
+		return c.arg(dot, n)
+	}
+}
+
+// Returns the type of an argument and checks the fields it accesses
+func (c *typeChecker) arg(dot reflect.Type, node parse.Node) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return c.fields(node, dot, n.Ident)
+	case *parse.VariableNode:
+		return c.fields(node, c.variable(n.Ident[0]), n.Ident[1:])
+	case *parse.ChainNode:
+		return c.fields(node, c.arg(dot, n.Node), n.Field)
+	case *parse.PipeNode:
+		return c.cmds(dot, n.Cmds)
+	case *parse.IdentifierNode:
+		return c.funcResult(n.Ident)
+	case *parse.BoolNode:
+		return reflect.TypeOf(false)
+	case *parse.StringNode:
+		return stringType
+	}
+
+	return nil
+}
+
+func (c *typeChecker) variable(name string) reflect.Type {
+	for i := len(c.vars) - 1; i >= 0; i-- {
+		if c.vars[i].name == name {
+			return c.vars[i].typ
+		}
+	}
+	return nil
+}
+
+// Returns the type of the first result of the function, or nil if it is unknown
+func (c *typeChecker) funcResult(name string) reflect.Type {
+	if fn, ok := c.funcs[name]; ok {
+		typ := reflect.TypeOf(fn)
+		if typ != nil && typ.Kind() == reflect.Func && typ.NumOut() > 0 {
+			return typ.Out(0)
+		}
+		return nil
+	}
+
+	return builtinResults[name]
+}
+
+// Follows the chain of fields and methods from typ, the same
+// way text/template evaluates them, and returns the resulting type
+func (c *typeChecker) fields(node parse.Node, typ reflect.Type, chain []string) reflect.Type {
+	for _, name := range chain {
+		if typ == nil {
+			return nil
+		}
+
+		// Pointers are indirected, the value they point to is addressable
+		addr := false
+		for typ.Kind() == reflect.Pointer {
+			typ, addr = typ.Elem(), true
+		}
+		if typ.Kind() == reflect.Interface {
+			return nil
+		}
+
+		// Methods with pointer receivers can only be called on addressable values
+		receiver := typ
+		if addr {
+			receiver = reflect.PointerTo(typ)
+		}
+		if method, ok := receiver.MethodByName(name); ok {
+			if method.Type.NumOut() == 0 {
+				return nil
+			}
+			typ = method.Type.Out(0)
+			continue
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			field, ok := typ.FieldByName(name)
+			if ok && field.IsExported() {
+				typ = field.Type
+				if addr {
+					typ = addressable(typ)
+				}
+				continue
+			}
+			if ok {
+				c.errorf(node, "%s is an unexported field of struct type %s", name, typ)
+				return nil
+			}
+		case reflect.Map:
+			if stringType.AssignableTo(typ.Key()) {
+				typ = typ.Elem()
+				continue
+			}
+		}
+
+		c.errorf(node, "can't evaluate field %s in type %s", name, typ)
+		return nil
+	}
+
+	return typ
+}
+
+// Returns the type tracking an addressable value of typ, see typeChecker
+func addressable(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Interface {
+		return typ
+	}
+	return reflect.PointerTo(typ)
+}
+
+// Records a TemplateError with the location of the node
+func (c *typeChecker) errorf(node parse.Node, format string, args ...any) {
+	location, context := c.tree.ErrorContext(node)
+	err := fmt.Errorf("%w: template: %s: checking %q at <%s>: %s", ErrTemplateType, location, c.tree.Name, context, fmt.Sprintf(format, args...))
+	c.errs = append(c.errs, newTemplateError(c.ctx, c.name, err))
+}